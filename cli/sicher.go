@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	"github.com/dsa0x/sicher"
 )
@@ -16,24 +17,72 @@ var (
 	editorFlag        string
 	styleFlag         string
 	gitignorePathFlag string
+	backupFlag        bool
+	passphraseFlag    bool
+	kdfFlag           string
+	identityFlag      bool
+	cipherFlag        string
+	storeFlag         string
+	keysFlag          string
 )
 
 var writer io.Writer = os.Stderr
+var out io.Writer = os.Stdout
 
 var errHelp = `
 # Initialize sicher in your project
 sicher init
 
+# Initialize with a passphrase instead of a key file
+sicher init -passphrase
+
+# Initialize with another cipher
+sicher init -cipher xchacha20-poly1305
+
+# Initialize with the key saved in the OS keyring instead of a key file
+sicher init -store keyring
+
 # Edit environment variables
 sicher edit
+
+# Check the credentials for invalid lines, duplicate keys, empty values, trailing whitespace and unquoted $
+sicher lint [-env dev]
+
+# Replace the encryption key and re-encrypt the credentials
+sicher rotate [-keys <keys of the other recipients>]
+
+# Print a new random key, e.g. for SICHER_MASTER_KEY
+sicher keygen
+
+# Print a new X25519 identity and its public key
+sicher keygen -identity
+
+# Add a secret using only the public keys of the recipients
+sicher add [-env prod] KEY=value
+
+# Move the key file into the OS keyring, or write the key from the keyring to the key file
+sicher key import [-env dev]
+sicher key export [-env dev]
+
+# Manage the keys that can decrypt the credentials
+sicher recipients add [-env dev] [key]
+sicher recipients remove [-env dev] [-keys <keys of the other recipients>] <key id>
+sicher recipients list [-env dev]
 `
 
 func init() {
 	flag.StringVar(&pathFlag, "path", ".", "Path to the project")
 	flag.StringVar(&envFlag, "env", "dev", "Environment to use")
-	flag.StringVar(&styleFlag, "style", string(sicher.DefaultEnvStyle), "Env file style. Valid values are dotenv and yaml")
+	flag.StringVar(&styleFlag, "style", string(sicher.DefaultEnvStyle), "Env file style. Valid values are dotenv, yaml, json and toml")
 	flag.StringVar(&editorFlag, "editor", "vim", "Select editor.")
 	flag.StringVar(&gitignorePathFlag, "gitignore", ".", "Path to the gitignore file")
+	flag.BoolVar(&backupFlag, "backup", false, "Keep a backup of the old key when rotating")
+	flag.BoolVar(&passphraseFlag, "passphrase", false, "Protect the credentials with a passphrase instead of a key file")
+	flag.StringVar(&kdfFlag, "kdf", sicher.DefaultKDF, "Key derivation function for passphrases. Valid values are scrypt and argon2id")
+	flag.BoolVar(&identityFlag, "identity", false, "Generate an X25519 identity instead of a key")
+	flag.StringVar(&storeFlag, "store", sicher.KeyStoreFile, "Where to save the key of new credentials. Valid values are file and keyring")
+	flag.StringVar(&keysFlag, "keys", "", "Comma separated keys of the other recipients, needed when removing a recipient or rotating")
+	flag.StringVar(&cipherFlag, "cipher", sicher.DefaultCipher, "Cipher for new credentials. Valid values are "+strings.Join(sicher.Ciphers(), ", "))
 
 	flag.ErrHelp = errors.New(errHelp)
 	flag.Usage = func() {
@@ -48,19 +97,204 @@ func Execute() {
 		os.Exit(1)
 	}
 	command := os.Args[1]
-	flag.CommandLine.Parse(os.Args[2:])
+	args := os.Args[2:]
+
+	// commands with subcommands parse the flags following the subcommand
+	var subcommand string
+	if (command == "recipients" || command == "key") && len(args) > 0 {
+		subcommand, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if command == "keygen" {
+		err := keygen()
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	s := sicher.New(envFlag, pathFlag)
-	s.SetEnvStyle(styleFlag)
+	if err := s.SetEnvStyle(styleFlag); err != nil {
+		fmt.Fprintln(writer, err)
+		os.Exit(1)
+	}
+	s.SetRecipientKeys(strings.Split(keysFlag, ",")...)
 	switch command {
 	case "init":
-		s.Initialize(os.Stdin)
+		err := s.SetCipher(cipherFlag)
+		if err == nil {
+			err = s.SetKeyStore(storeFlag)
+		}
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+		if passphraseFlag {
+			err := s.UsePassphrase(kdfFlag)
+			if err != nil {
+				fmt.Fprintln(writer, err)
+				os.Exit(1)
+			}
+		}
+		err = s.Initialize(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
 	case "edit":
 		err := s.Edit(editorFlag)
 		if err != nil {
 			fmt.Fprintln(writer, err)
 			os.Exit(1)
 		}
+	case "rotate":
+		err := s.RotateKey(backupFlag)
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+	case "add":
+		if flag.NArg() < 1 || !strings.Contains(flag.Arg(0), "=") {
+			fmt.Fprintln(writer, "usage: sicher add KEY=value")
+			os.Exit(1)
+		}
+		kv := strings.SplitN(flag.Arg(0), "=", 2)
+		err := s.AddSecret(kv[0], kv[1])
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+	case "recipients":
+		err := recipients(s, subcommand, flag.Args())
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+	case "key":
+		err := key(s, subcommand)
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+	case "lint":
+		err := lint(s)
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
 	default:
 		flag.Usage()
 	}
 }
+
+// keygen prints a new key, or a new identity and its public key
+func keygen() error {
+	if identityFlag {
+		identity, publicKey, err := sicher.GenerateIdentity()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "# public key: %s\n%s\n", publicKey, identity)
+		return nil
+	}
+
+	key, err := sicher.GenerateKey()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, key)
+	return nil
+}
+
+// recipients runs the recipients subcommands
+func recipients(s recipientManager, subcommand string, args []string) error {
+	switch subcommand {
+	case "add":
+		var key string
+		if len(args) > 0 {
+			key = args[0]
+		} else {
+			// generate a key for the new recipient, to be handed over out of band
+			var err error
+			key, err = sicher.GenerateKey()
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Generated key for the new recipient:\n%s\n", key)
+		}
+		return s.AddRecipient(key)
+	case "remove":
+		if len(args) < 1 {
+			return errors.New("missing key id of the recipient to remove, see sicher recipients list")
+		}
+		return s.RemoveRecipient(args[0])
+	case "list":
+		ids, err := s.ListRecipients()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			fmt.Fprintln(out, "The credentials are encrypted with a single key and have no recipients.")
+		}
+		for _, id := range ids {
+			fmt.Fprintln(out, id)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown recipients command %q, use one of add, remove or list", subcommand)
+	}
+}
+
+// key runs the key subcommands
+func key(s keyManager, subcommand string) error {
+	switch subcommand {
+	case "import":
+		err := s.ImportKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Key moved into the keyring.")
+		return nil
+	case "export":
+		err := s.ExportKey()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Key written to the key file.")
+		return nil
+	default:
+		return fmt.Errorf("unknown key command %q, use one of import or export", subcommand)
+	}
+}
+
+// lint prints the problems of the credentials, failing if there are any
+func lint(s linter) error {
+	problems, err := s.Lint()
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(out, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems in the credentials", len(problems))
+	}
+	fmt.Fprintln(out, "No problems found.")
+	return nil
+}
+
+type linter interface {
+	Lint() ([]sicher.LineError, error)
+}
+
+type keyManager interface {
+	ImportKey() error
+	ExportKey() error
+}
+
+type recipientManager interface {
+	AddRecipient(key string) error
+	RemoveRecipient(id string) error
+	ListRecipients() ([]string, error)
+}
@@ -2,8 +2,12 @@ package cli
 
 import (
 	"bytes"
+	"encoding/hex"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/dsa0x/sicher"
 )
 
 func TestInvalidCmd(t *testing.T) {
@@ -37,3 +41,74 @@ func TestInitCmd(t *testing.T) {
 		os.Remove(".gitignore")
 	})
 }
+
+func TestKeygenCmd(t *testing.T) {
+	oldOut := out
+	defer func() { out = oldOut }()
+	b := bytes.Buffer{}
+
+	out = &b
+	os.Args = []string{"sicher", "keygen"}
+	Execute()
+
+	key, err := hex.DecodeString(strings.TrimSpace(b.String()))
+	if err != nil || len(key) != 32 {
+		t.Fatalf("Expected a 32 byte hex encoded key to be printed, got %q", b.String())
+	}
+}
+
+type fakeKeyManager struct {
+	imported, exported bool
+}
+
+func (f *fakeKeyManager) ImportKey() error {
+	f.imported = true
+	return nil
+}
+
+func (f *fakeKeyManager) ExportKey() error {
+	f.exported = true
+	return nil
+}
+
+func TestKeyCmd(t *testing.T) {
+	oldOut := out
+	defer func() { out = oldOut }()
+	out = &bytes.Buffer{}
+
+	f := &fakeKeyManager{}
+	if err := key(f, "import"); err != nil || !f.imported {
+		t.Errorf("Expected key to be imported, got %v", err)
+	}
+	if err := key(f, "export"); err != nil || !f.exported {
+		t.Errorf("Expected key to be exported, got %v", err)
+	}
+	if err := key(f, "delete"); err == nil {
+		t.Errorf("Expected unknown key command to fail")
+	}
+}
+
+type fakeLinter []sicher.LineError
+
+func (f fakeLinter) Lint() ([]sicher.LineError, error) {
+	return f, nil
+}
+
+func TestLintCmd(t *testing.T) {
+	oldOut := out
+	defer func() { out = oldOut }()
+	buf := &bytes.Buffer{}
+	out = buf
+
+	if err := lint(fakeLinter{}); err != nil {
+		t.Errorf("Expected no error without problems, got %v", err)
+	}
+
+	problems := fakeLinter{{Line: 2, Key: "PORT", Reason: "duplicate key PORT overrides the value of line 1"}}
+	if err := lint(problems); err == nil {
+		t.Errorf("Expected lint to fail with problems")
+	}
+	if !strings.Contains(buf.String(), "line 2: duplicate key PORT") {
+		t.Errorf("Expected the problems to be printed, got %q", buf.String())
+	}
+}
@@ -1,65 +1,86 @@
 package sicher
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"os"
 )
 
-// configure reads the credentials file and sets the environment variables
-func (s *sicher) configure() {
-
-	if s.Environment == "" {
-		fmt.Println("Environment not set")
-		return
-	}
-	// read the encryption key
-	strKey, err := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment))
+// configure reads the credentials file and sets the environment variables.
+// It returns an error matching one of the sentinel errors, like ErrKeyNotFound, if the credentials
+// cannot be opened, and the *ParseError of credentials which cannot be parsed
+func (s *Sicher) configure() error {
+	plaintext, err := s.decryptCredentials()
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
+	return parseConfigMode(plaintext, s.data, s.envStyle, s.strict)
+}
 
+// decryptCredentials reads and decrypts the credentials file, including the secrets added with public keys
+func (s *Sicher) decryptCredentials() ([]byte, error) {
+	if s.Environment == "" {
+		return nil, errors.New("environment not set")
+	}
 	// read the encrypted credentials file
-	credFile, err := os.ReadFile(fmt.Sprintf("%s%s.enc", s.Path, s.Environment))
+	credFile, err := s.readCredentialsFile()
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, withSentinel(ErrCredentialsNotFound, fmt.Errorf("encrypted credentials file (%s.enc) is not available. Create one by running the cli with init flag", s.Environment))
+	}
 	if err != nil {
-		fmt.Printf("encrypted credentials file (%s.enc) is not available. Create one by running the cli with init flag.\n", s.Environment)
-		return
+		return nil, fmt.Errorf("error reading encrypted credentials file: %w", err)
 	}
 
 	encFile := string(credFile)
 
 	// if file already exists, decode and decrypt it
-	nonce, fileText, err := decodeFile(encFile)
+	envFile, err := decodeFile(encFile)
 	if err != nil {
-		fmt.Printf("Error decoding encryption file: %s\n", err)
-		return
+		return nil, withSentinel(ErrCorruptFile, fmt.Errorf("error decoding encryption file: %s", err))
 	}
 
-	if nonce == nil || fileText == nil {
-		fmt.Println("Error decoding encryption file: encrypted file is invalid")
-		return
+	if envFile == nil {
+		return nil, withSentinel(ErrCorruptFile, errors.New("error decoding encryption file: encrypted file is invalid"))
 	}
 
-	plaintext, err := decrypt(strKey, nonce, fileText)
+	// read the encryption key. errors of key providers, like an unreachable KMS, also leave the key unavailable
+	strKey, err := s.encryptionKey(envFile, false)
 	if err != nil {
-		fmt.Println("Error decrypting file:", err)
-		return
+		return nil, withSentinel(ErrKeyNotFound, err)
 	}
 
-	err = parseConfig(plaintext, s.data, s.envStyle)
+	plaintext, err := openFile(strKey, envFile, s.Environment, s.envStyle)
 	if err != nil {
-		fmt.Printf("Error parsing env file: %s\n", err)
-		return
+		return nil, withSentinel(ErrDecryptionFailed, fmt.Errorf("error decrypting file: %s", err))
 	}
 
+	plaintext, err = s.openPending(envFile, plaintext)
+	if err != nil {
+		return nil, withSentinel(ErrDecryptionFailed, err)
+	}
+	return plaintext, nil
+}
+
+// readCredentialsFile returns the content of {environment}.enc, read from the file system set with WithFS
+// or else from the project path
+func (s *Sicher) readCredentialsFile() ([]byte, error) {
+	if s.fsys != nil {
+		return fs.ReadFile(s.fsys, fmt.Sprintf("%s.enc", s.Environment))
+	}
+	return os.ReadFile(fmt.Sprintf("%s%s.enc", s.Path, s.Environment))
 }
 
-func (s *sicher) setEnv() {
-	for k, v := range s.data {
-		err := os.Setenv(k, fmt.Sprintf("%v", v))
+// setEnv sets values as environment variables, except those taken from the environment
+func (s *Sicher) setEnv(values map[string]string) error {
+	for k, v := range values {
+		if s.sources[k] == SourceEnvironment {
+			continue
+		}
+		err := os.Setenv(k, v)
 		if err != nil {
-			log.Fatalf("Error setting environment variable key %s: %s\n", k, err)
+			return fmt.Errorf("error setting environment variable key %s: %s", k, err)
 		}
 	}
+	return nil
 }
@@ -0,0 +1,244 @@
+package sicher
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// default separators of slice elements and map entries, and of the key and value of map entries.
+// They can be changed with the separator and kvseparator struct tags
+const (
+	defaultSeparator   = ","
+	defaultKVSeparator = ":"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// bindStruct sets the fields of the struct d from the variables named by their env tag, preceded by prefix,
+// as returned by getenv. Nested structs are bound recursively and pointers to structs are allocated when one
+// of their variables is set. It reports whether any variable was set. Self-referencing struct types are rejected
+func bindStruct(d reflect.Value, prefix string, getenv func(string) string) (bool, error) {
+	return bindFields(d, prefix, getenv, make(map[reflect.Type]bool))
+}
+
+// bindFields binds the fields of the struct d. visiting holds the struct types being bound by the callers
+func bindFields(d reflect.Value, prefix string, getenv func(string) string, visiting map[reflect.Type]bool) (bool, error) {
+	if visiting[d.Type()] {
+		return false, fmt.Errorf("recursive struct type %s is not supported", d.Type())
+	}
+	visiting[d.Type()] = true
+	defer delete(visiting, d.Type())
+
+	var found bool
+	for i := 0; i < d.NumField(); i++ {
+		field := d.Field(i)
+		fieldType := d.Type().Field(i)
+		isRequired := fieldType.Tag.Get("required")
+		key := fieldType.Tag.Get("env")
+
+		tagName := key
+		if prefix != "" {
+			tagName = fmt.Sprintf("%s_%s", prefix, key)
+		}
+
+		if isNestedStruct(field.Type()) && (fieldType.IsExported() || fieldType.Anonymous) {
+			// embedded structs and structs without env tag are flattened into the parent
+			nestedPrefix := prefix
+			if key != "" {
+				nestedPrefix = tagName
+			}
+
+			set, err := bindNested(field, nestedPrefix, getenv, visiting)
+			if err != nil {
+				return false, err
+			}
+			found = found || set
+			continue
+		}
+
+		envVar := getenv(tagName)
+		if isRequired == "true" && envVar == "" {
+			return false, errors.New("required env variable " + tagName + " is not set")
+		}
+
+		if key == "" || !field.CanSet() {
+			continue
+		}
+		err := setField(field, fieldType.Tag, envVar)
+		if err != nil {
+			return false, fmt.Errorf("invalid value for env variable %s: %s", tagName, err)
+		}
+		found = found || envVar != ""
+	}
+	return found, nil
+}
+
+// bindNested binds a struct or pointer to struct field. A nil pointer is only
+// allocated if one of the variables of the struct is set
+func bindNested(field reflect.Value, prefix string, getenv func(string) string, visiting map[reflect.Type]bool) (bool, error) {
+	if field.Kind() != reflect.Ptr {
+		return bindFields(field, prefix, getenv, visiting)
+	}
+
+	if !field.IsNil() {
+		return bindFields(field.Elem(), prefix, getenv, visiting)
+	}
+
+	if !field.CanSet() {
+		return false, nil
+	}
+	ptr := reflect.New(field.Type().Elem())
+	set, err := bindFields(ptr.Elem(), prefix, getenv, visiting)
+	if err != nil || !set {
+		return false, err
+	}
+	field.Set(ptr)
+	return true, nil
+}
+
+// isNestedStruct reports whether t is a struct, or pointer to a struct, whose fields are bound separately.
+// Structs which are decoded from a single value, like url.URL or time.Time, are not nested
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != urlType && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// setField converts value to the type of field and sets it. tag holds the separator and kvseparator
+// tags of the struct field. An empty value sets the zero value
+func setField(field reflect.Value, tag reflect.StructTag, value string) error {
+	if value == "" {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	separator, ok := tag.Lookup("separator")
+	if !ok {
+		separator = defaultSeparator
+	}
+	kvSeparator, ok := tag.Lookup("kvseparator")
+	if !ok {
+		kvSeparator = defaultKVSeparator
+	}
+
+	// types implementing encoding.TextUnmarshaler, like net.IP, parse the whole value
+	if reflect.PtrTo(field.Type()).Implements(textUnmarshalerType) {
+		return setValue(field, value)
+	}
+
+	switch field.Kind() {
+	case reflect.Slice:
+		// []byte is set from the raw value, other slices from the separated elements
+		if field.Type().Elem().Kind() == reflect.Uint8 {
+			field.SetBytes([]byte(value))
+			return nil
+		}
+
+		parts := strings.Split(value, separator)
+		slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setValue(slice.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	case reflect.Map:
+		m := reflect.MakeMap(field.Type())
+		for _, entry := range strings.Split(value, separator) {
+			kv := strings.SplitN(entry, kvSeparator, 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid map entry %q, expected key%svalue", entry, kvSeparator)
+			}
+
+			k := reflect.New(field.Type().Key()).Elem()
+			if err := setValue(k, strings.TrimSpace(kv[0])); err != nil {
+				return err
+			}
+			v := reflect.New(field.Type().Elem()).Elem()
+			if err := setValue(v, strings.TrimSpace(kv[1])); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, v)
+		}
+		field.Set(m)
+		return nil
+	}
+	return setValue(field, value)
+}
+
+// setValue converts value to the scalar type of v and sets it
+func setValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := setValue(ptr.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value))
+	}
+
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case urlType:
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
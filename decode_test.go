@@ -0,0 +1,272 @@
+package sicher
+
+import (
+	"net"
+	neturl "net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCredentials creates a key file and credentials of the given style holding content in a temporary project
+func writeCredentials(t *testing.T, style EnvStyle, content string) *Sicher {
+	t.Helper()
+	t.Setenv(masterKey, "")
+	s := New("testenv", t.TempDir())
+	s.envStyle = style
+
+	key := testKey(t)
+	sealed, err := sealFile(key, []byte(content), s.newEnvelope())
+	if err != nil {
+		t.Fatalf("Unable to seal credentials; got error %v", err)
+	}
+	os.WriteFile(s.Path+s.Environment+".key", []byte(key), 0600)
+	os.WriteFile(s.Path+s.Environment+".enc", sealed, 0600)
+	return s
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+	s := writeCredentials(t, DOTENV, strings.Join([]string{
+		"TYPES_PORT=8080",
+		"TYPES_RATIO=0.75",
+		"TYPES_DEBUG=1",
+		"TYPES_WORKERS=16",
+		"TYPES_TIMEOUT=1m30s",
+		"TYPES_HOSTS=a.example.com, b.example.com",
+		"TYPES_PORTS=80;443",
+		"TYPES_LABELS=team:core,tier:1",
+		"TYPES_LIMITS=a=1|b=2",
+		"TYPES_URL=https://example.com/path?q=1",
+		"TYPES_IP=10.0.0.1",
+		"TYPES_RETRIES=3",
+		"",
+	}, "\n"))
+
+	var cfg struct {
+		Port    int               `env:"PORT"`
+		Ratio   float64           `env:"RATIO"`
+		Debug   bool              `env:"DEBUG"`
+		Workers uint8             `env:"WORKERS"`
+		Timeout time.Duration     `env:"TIMEOUT"`
+		Hosts   []string          `env:"HOSTS"`
+		Ports   []int             `env:"PORTS" separator:";"`
+		Labels  map[string]string `env:"LABELS"`
+		Limits  map[string]int    `env:"LIMITS" separator:"|" kvseparator:"="`
+		URL     neturl.URL        `env:"URL"`
+		IP      net.IP            `env:"IP"`
+		Retries *int              `env:"RETRIES"`
+		Missing int               `env:"MISSING"`
+	}
+
+	err := s.LoadEnv("TYPES", &cfg)
+	if err != nil {
+		t.Fatalf("Expected to load typed config; got error %v", err)
+	}
+
+	if cfg.Port != 8080 || cfg.Ratio != 0.75 || !cfg.Debug || cfg.Workers != 16 || cfg.Timeout != 90*time.Second {
+		t.Errorf("Unexpected scalar values %+v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Hosts, []string{"a.example.com", "b.example.com"}) || !reflect.DeepEqual(cfg.Ports, []int{80, 443}) {
+		t.Errorf("Unexpected slices %v, %v", cfg.Hosts, cfg.Ports)
+	}
+	if !reflect.DeepEqual(cfg.Labels, map[string]string{"team": "core", "tier": "1"}) || !reflect.DeepEqual(cfg.Limits, map[string]int{"a": 1, "b": 2}) {
+		t.Errorf("Unexpected maps %v, %v", cfg.Labels, cfg.Limits)
+	}
+	if cfg.URL.Host != "example.com" || cfg.URL.Query().Get("q") != "1" {
+		t.Errorf("Unexpected url %v", cfg.URL)
+	}
+	if !cfg.IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("Unexpected ip %v", cfg.IP)
+	}
+	if cfg.Retries == nil || *cfg.Retries != 3 || cfg.Missing != 0 {
+		t.Errorf("Unexpected values %v, %d", cfg.Retries, cfg.Missing)
+	}
+}
+
+func TestLoadEnvConversionError(t *testing.T) {
+	tests := []struct {
+		name   string
+		config interface{}
+	}{
+		{name: "int", config: &struct {
+			Port int `env:"CONV_PORT"`
+		}{}},
+		{name: "slice", config: &struct {
+			Ports []int `env:"CONV_PORT"`
+		}{}},
+		{name: "map", config: &struct {
+			Ports map[string]string `env:"CONV_PORT"`
+		}{}},
+		{name: "unsupported", config: &struct {
+			Ports chan int `env:"CONV_PORT"`
+		}{}},
+	}
+
+	s := writeCredentials(t, DOTENV, "CONV_PORT=http\n")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.LoadEnv("", tt.config)
+			if err == nil || !strings.Contains(err.Error(), "CONV_PORT") {
+				t.Errorf("Expected conversion error naming the key, got %v", err)
+			}
+		})
+	}
+}
+
+type Timeouts struct {
+	Read  time.Duration `env:"READ_TIMEOUT"`
+	Write time.Duration `env:"WRITE_TIMEOUT"`
+}
+
+func TestLoadEnvNestedStructs(t *testing.T) {
+	s := writeCredentials(t, DOTENV, strings.Join([]string{
+		"APP_NAME=sicher",
+		"APP_DB_HOST=localhost",
+		"APP_DB_PORT=5432",
+		"APP_DB_REPLICA_HOST=replica",
+		"APP_READ_TIMEOUT=5s",
+		"APP_CACHE_URL=redis://localhost",
+		"",
+	}, "\n"))
+
+	type db struct {
+		Host    string `env:"HOST"`
+		Port    int    `env:"PORT"`
+		Replica struct {
+			Host string `env:"HOST"`
+		} `env:"REPLICA"`
+	}
+	type cache struct {
+		URL string `env:"URL"`
+	}
+
+	var cfg struct {
+		Timeouts
+		Name    string `env:"NAME"`
+		DB      db     `env:"DB"`
+		Cache   *cache `env:"CACHE"`
+		Queue   *cache `env:"QUEUE"`
+		Created time.Time
+	}
+
+	err := s.LoadEnv("APP", &cfg)
+	if err != nil {
+		t.Fatalf("Expected to load nested config; got error %v", err)
+	}
+
+	if cfg.Name != "sicher" || cfg.DB.Host != "localhost" || cfg.DB.Port != 5432 || cfg.DB.Replica.Host != "replica" {
+		t.Errorf("Unexpected nested values %+v", cfg)
+	}
+	if cfg.Read != 5*time.Second || cfg.Write != 0 {
+		t.Errorf("Expected embedded struct to be flattened, got %+v", cfg.Timeouts)
+	}
+	if cfg.Cache == nil || cfg.Cache.URL != "redis://localhost" {
+		t.Errorf("Expected pointer to struct to be allocated, got %+v", cfg.Cache)
+	}
+	if cfg.Queue != nil {
+		t.Errorf("Expected pointer to struct without variables to stay nil, got %+v", cfg.Queue)
+	}
+
+	var required struct {
+		DB struct {
+			User string `env:"USER" required:"true"`
+		} `env:"DB"`
+	}
+	err = s.LoadEnv("APP", &required)
+	if err == nil || !strings.Contains(err.Error(), "APP_DB_USER") {
+		t.Errorf("Expected missing nested variable to be reported, got %v", err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type Config struct {
+		Port    int    `env:"DECODE_PORT" required:"true"`
+		AppName string `env:"DECODE_APP_NAME"`
+	}
+	s := writeCredentials(t, DOTENV, "DECODE_PORT=8080\nDECODE_APP_NAME=sicher\n")
+
+	var cfg Config
+	if err := s.Decode("", &cfg); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if cfg.Port != 8080 || cfg.AppName != "sicher" {
+		t.Errorf("Expected the credentials to be decoded; got %+v", cfg)
+	}
+	if _, ok := os.LookupEnv("DECODE_PORT"); ok {
+		t.Error("Expected Decode not to set environment variables")
+	}
+
+	var m map[string]string
+	if err := s.Decode("", &m); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	m["DECODE_PORT"] = "9090"
+	if s.data["DECODE_PORT"] != "8080" {
+		t.Error("Expected the decoded map to be a copy of the credentials")
+	}
+}
+
+func TestDecodeRequiredFromEnvironment(t *testing.T) {
+	type Config struct {
+		Token string `env:"DECODE_TOKEN" required:"true"`
+	}
+	t.Setenv("DECODE_TOKEN", "from-env")
+	s := writeCredentials(t, DOTENV, "DECODE_PORT=8080\n")
+
+	// Decode binds the credentials only
+	var cfg Config
+	if err := s.Decode("", &cfg); err == nil {
+		t.Error("Expected an error for a required variable missing from the credentials")
+	}
+}
+
+type recursiveNode struct {
+	Name string         `env:"NAME"`
+	Next *recursiveNode `env:"NEXT"`
+}
+
+type recursiveSelf struct {
+	Name string `env:"NAME"`
+	Self *recursiveSelf
+}
+
+func TestDecodeRecursiveStruct(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "NAME=a\nNEXT_NAME=b\n")
+
+	var node recursiveNode
+	if err := s.Decode("", &node); err == nil || !strings.Contains(err.Error(), "recursive struct type") {
+		t.Errorf("Expected an error for a recursive struct; got %v", err)
+	}
+	var self recursiveSelf
+	if err := s.Decode("", &self); err == nil || !strings.Contains(err.Error(), "recursive struct type") {
+		t.Errorf("Expected an error for an untagged recursive struct; got %v", err)
+	}
+
+	// the same struct type may be used by several fields
+	type Config struct {
+		Primary struct {
+			Name string `env:"NAME"`
+		} `env:"NEXT"`
+		Replica struct {
+			Name string `env:"NAME"`
+		} `env:"NEXT"`
+	}
+	var cfg Config
+	if err := s.Decode("", &cfg); err != nil || cfg.Primary.Name != "b" || cfg.Replica.Name != "b" {
+		t.Errorf("Expected repeated struct types to be decoded; got %+v, %v", cfg, err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Setenv("EXPORT_PORT", "")
+	s := writeCredentials(t, DOTENV, "EXPORT_PORT=8080\n")
+
+	if err := s.Export(); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if os.Getenv("EXPORT_PORT") != "8080" {
+		t.Errorf("Expected EXPORT_PORT to be exported; got %q", os.Getenv("EXPORT_PORT"))
+	}
+}
@@ -0,0 +1,228 @@
+package sicher
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseDotenv parses a dotenv file into the store, following the grammar of godotenv and Ruby dotenv:
+//
+//	# comment
+//	export KEY=value # inline comment
+//	SINGLE='literal $value'
+//	DOUBLE="escaped\tvalue with ${KEY}"
+//	PEM="-----BEGIN KEY-----
+//	...
+//	-----END KEY-----"
+//
+// Double-quoted and unquoted values expand $KEY and ${KEY} with the keys defined before them,
+// or else with the environment. Lines which are not assignments are skipped, and text following a
+// quoted value other than a comment is ignored
+func (p *configParser) parseDotenv(config []byte) {
+	lines := strings.Split(strings.ReplaceAll(string(config), "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimLeft(lines[i], " \t")
+		if canIgnore(line) {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			p.skip(lineNo, "", `missing "=" between key and value`)
+			continue
+		}
+		key := strings.TrimSpace(line[:eq])
+
+		raw := line[eq+1:]
+		rest := strings.TrimLeft(raw, " \t")
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			value := stripInlineComment(raw)
+			if hasReference(value) {
+				// values were taken literally before expansion was supported, e.g. DB_PASS=ab$cd
+				p.warnings = append(p.warnings, LineError{Line: lineNo, Key: key,
+					Reason: fmt.Sprintf("unquoted value of %s expands $: double-quote it to expand, or single-quote it to keep it literal", key)})
+			}
+			p.set(lineNo, key, expandValue(value, p.store, false))
+			continue
+		}
+
+		// quoted values end at the matching quote, which may be on a following line
+		quote := rest[0]
+		body := rest[1:]
+		end := closingQuote(body, quote)
+		for end < 0 {
+			i++
+			if i >= len(lines) {
+				p.fail(lineNo, key, fmt.Sprintf("unterminated quoted value of %s", key))
+				return
+			}
+			body += "\n" + lines[i]
+			end = closingQuote(body, quote)
+		}
+
+		// only a comment may follow the closing quote
+		if trailing := strings.TrimSpace(body[end+1:]); trailing != "" && trailing[0] != '#' {
+			p.skip(lineNo, key, fmt.Sprintf("unexpected %q after the quoted value of %s", trailing, key))
+		}
+
+		if quote == '\'' {
+			p.set(lineNo, key, body[:end])
+		} else {
+			p.set(lineNo, key, expandValue(body[:end], p.store, true))
+		}
+	}
+}
+
+// quoteLiteralValues single-quotes the unquoted values with a $ of dotenv credentials, which were taken literally
+// before format version 3, so that they are not expanded when read or sealed in the current format
+func quoteLiteralValues(config []byte) []byte {
+	lines := strings.Split(string(config), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimLeft(lines[i], " \t")
+		eq := strings.IndexByte(line, '=')
+		if canIgnore(line) || eq < 0 {
+			continue
+		}
+
+		raw := line[eq+1:]
+		rest := strings.TrimLeft(raw, " \t")
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			// skip the following lines of a multi-line value
+			body := rest[1:]
+			for closingQuote(body, rest[0]) < 0 && i+1 < len(lines) {
+				i++
+				body += "\n" + lines[i]
+			}
+			continue
+		}
+
+		value := stripInlineComment(raw)
+		if !hasReference(value) {
+			continue
+		}
+		quoted := "'" + value + "'"
+		if strings.ContainsRune(value, '\'') {
+			quoted = quoteDotenv(value)
+		}
+		start := len(lines[i]) - len(line) + eq + 1 + len(raw) - len(rest)
+		lines[i] = lines[i][:start] + quoted + lines[i][start+len(value):]
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// closingQuote returns the index of the quote ending a quoted value, or -1.
+// Double quotes can be escaped with a backslash
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// stripInlineComment removes a comment, which starts with a # preceded by whitespace, from an unquoted value
+func stripInlineComment(value string) string {
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = value[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// expandValue replaces $KEY and ${KEY} in value with the value of KEY in store, or in the environment.
+// If escapes is true, backslash escapes of double-quoted values are interpreted, and \$ is a literal $
+func expandValue(value string, store map[string]string, escapes bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		if c == '\\' && escapes && i+1 < len(value) {
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(value[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(value[i])
+			}
+			continue
+		}
+
+		if c != '$' || i+1 == len(value) {
+			b.WriteByte(c)
+			continue
+		}
+
+		var name string
+		if value[i+1] == '{' {
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				b.WriteByte(c)
+				continue
+			}
+			name = value[i+2 : i+2+end]
+			i += end + 2
+		} else {
+			j := i + 1
+			for j < len(value) && isNameChar(value[j]) {
+				j++
+			}
+			if j == i+1 {
+				b.WriteByte(c)
+				continue
+			}
+			name = value[i+1 : j]
+			i = j - 1
+		}
+
+		if v, ok := store[name]; ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(os.Getenv(name))
+		}
+	}
+	return b.String()
+}
+
+// hasReference reports whether value refers to a key with $KEY or ${KEY}, which expandValue replaces
+func hasReference(value string) bool {
+	for i := 0; i+1 < len(value); i++ {
+		if value[i] == '$' && (isNameChar(value[i+1]) || (value[i+1] == '{' && strings.IndexByte(value[i+2:], '}') >= 0)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// quoteDotenv returns value as written in a dotenv file, double-quoted if it would otherwise not be read back unchanged
+func quoteDotenv(value string) string {
+	if !strings.ContainsAny(value, "\n\r\t\"'#$\\") && strings.TrimSpace(value) == value {
+		return value
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(value) + `"`
+}
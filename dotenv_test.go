@@ -0,0 +1,168 @@
+package sicher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	t.Setenv("SICHER_TEST_HOME", "/home/sicher")
+
+	cfg := []byte(`
+# comment
+PORT=8080
+export HOST=localhost
+SPACED = spaced value
+INLINE=value # inline comment
+HASH=pass#word
+SINGLE='literal $PORT \n # not a comment'
+DOUBLE="tab\tquote\" newline\n dollar \$PORT"
+URL=http://${HOST}:$PORT/path
+QUOTED_URL="${URL}?q=1"
+FROM_ENV=${SICHER_TEST_HOME}/.config
+MISSING=${SICHER_TEST_MISSING}
+EMPTY=
+EMPTY_QUOTED=""
+PEM="-----BEGIN KEY-----
+  abc
+-----END KEY-----"
+MULTI_SINGLE='one
+two'
+KEY=value=ndsjhjdghdhg
+PORT:9090
+invalid-key=ignored
+`)
+
+	store := make(map[string]string)
+	if err := parseConfig(cfg, store, DOTENV); err != nil {
+		t.Fatalf("Unable to parse config; %v", err)
+	}
+
+	expected := map[string]string{
+		"PORT":         "8080",
+		"HOST":         "localhost",
+		"SPACED":       "spaced value",
+		"INLINE":       "value",
+		"HASH":         "pass#word",
+		"SINGLE":       `literal $PORT \n # not a comment`,
+		"DOUBLE":       "tab\tquote\" newline\n dollar $PORT",
+		"URL":          "http://localhost:8080/path",
+		"QUOTED_URL":   "http://localhost:8080/path?q=1",
+		"FROM_ENV":     "/home/sicher/.config",
+		"MISSING":      "",
+		"EMPTY":        "",
+		"EMPTY_QUOTED": "",
+		"PEM":          "-----BEGIN KEY-----\n  abc\n-----END KEY-----",
+		"MULTI_SINGLE": "one\ntwo",
+		"KEY":          "value=ndsjhjdghdhg",
+	}
+	if !reflect.DeepEqual(store, expected) {
+		for k, v := range expected {
+			if store[k] != v {
+				t.Errorf("Expected %s to be %q, got %q", k, v, store[k])
+			}
+		}
+		for k := range store {
+			if _, ok := expected[k]; !ok {
+				t.Errorf("Unexpected key %s", k)
+			}
+		}
+	}
+}
+
+func TestParseDotenvUnterminatedQuote(t *testing.T) {
+	err := parseConfig([]byte("PORT=8080\nPEM=\"-----BEGIN KEY-----\nabc\n"), map[string]string{}, DOTENV)
+	if err == nil {
+		t.Errorf("Expected unterminated quoted value to be an error")
+	}
+}
+
+func TestEnvLineDotenv(t *testing.T) {
+	values := []string{"plain", "with space", "", " padded ", "pass#word", "a # b", `"quoted"`, "'single'", "$HOME", `back\slash`, "multi\nline"}
+	for _, value := range values {
+		store := make(map[string]string)
+		line := envLine(DOTENV, "KEY", value)
+		if err := parseConfig([]byte(line), store, DOTENV); err != nil {
+			t.Fatalf("Unable to parse %q; %v", line, err)
+		}
+		if store["KEY"] != value {
+			t.Errorf("Expected %q to be read back from %q, got %q", value, line, store["KEY"])
+		}
+	}
+}
+
+func TestParseDotenvTrailingText(t *testing.T) {
+	cfg := []byte("QUOTED=\"a\"trailing\nCOMMENT=\"b\" # comment\nPEM='one\ntwo' three\n")
+
+	store := make(map[string]string)
+	if err := parseConfig(cfg, store, DOTENV); err != nil || store["QUOTED"] != "a" || store["COMMENT"] != "b" {
+		t.Errorf("Expected the quoted values to be read outside of strict mode, got %v, %v", store, err)
+	}
+
+	var perr *ParseError
+	err := parseConfigMode(cfg, make(map[string]string), DOTENV, true)
+	if !errors.As(err, &perr) || len(perr.Lines) != 2 || perr.Lines[0].Key != "QUOTED" || perr.Lines[1].Key != "PEM" {
+		t.Errorf("Expected text after the quoted values to be an error in strict mode, got %v", err)
+	}
+}
+
+func TestQuoteLiteralValues(t *testing.T) {
+	cases := map[string]string{
+		"DB_PASS=ab$cd":                   "DB_PASS='ab$cd'",
+		"export URL = http://$HOST # dev": "export URL = 'http://$HOST' # dev",
+		"QUOTE=it's$HOME\r":               `QUOTE="it's\$HOME"` + "\r",
+		"PORT=8080":                       "PORT=8080",
+		"PRICE=5$":                        "PRICE=5$",
+		"SINGLE='$HOME'":                  "SINGLE='$HOME'",
+		"PEM=\"one\nKEY=$HOME\n\"":        "PEM=\"one\nKEY=$HOME\n\"",
+		"# KEY=$HOME":                     "# KEY=$HOME",
+	}
+	for in, expected := range cases {
+		if got := string(quoteLiteralValues([]byte(in))); got != expected {
+			t.Errorf("Expected %q to be quoted as %q, got %q", in, expected, got)
+		}
+	}
+}
+
+func TestOpenKeepsLiteralValuesOfOlderFormats(t *testing.T) {
+	t.Setenv("cd", "expanded")
+	key := testKey(t)
+	plaintext := []byte("DB_PASS=ab$cd\n")
+
+	// credentials sealed with format version 2, which took unquoted values literally
+	e := &envelope{version: 2, environment: "prod", style: DOTENV, cipher: CipherAESGCM, keyID: keyFingerprint(key)}
+	c, _ := lookupCipher(CipherAESGCM)
+	var err error
+	e.nonce, e.ciphertext, err = sealWith(c, key, plaintext, associatedData(2, "prod", DOTENV))
+	if err != nil {
+		t.Fatalf("Unable to encrypt; got error %v", err)
+	}
+
+	opened, err := Open(key, e.encode(), "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to open credentials; got error %v", err)
+	}
+	// resealing in the current format keeps the value
+	resealed, err := Seal(key, opened, "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to seal credentials; got error %v", err)
+	}
+	opened, err = Open(key, resealed, "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to open resealed credentials; got error %v", err)
+	}
+
+	store := make(map[string]string)
+	if err := parseConfig(opened, store, DOTENV); err != nil || store["DB_PASS"] != "ab$cd" {
+		t.Errorf("Expected the value of an older file to be literal, got %q, %v", store["DB_PASS"], err)
+	}
+
+	// credentials sealed in the current format expand it
+	sealed, _ := Seal(key, plaintext, "prod", DOTENV)
+	opened, _ = Open(key, sealed, "prod", DOTENV)
+	store = make(map[string]string)
+	if err := parseConfig(opened, store, DOTENV); err != nil || store["DB_PASS"] != "abexpanded" {
+		t.Errorf("Expected the value to be expanded, got %q, %v", store["DB_PASS"], err)
+	}
+}
@@ -5,51 +5,170 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
-// encrypt encrypts the given plaintext with the given key and returns the ciphertext
-func encrypt(key string, fileData []byte) (nonce []byte, ciphertext []byte, err error) {
-	hKey, err := hex.DecodeString(key)
+// identifiers of the supported ciphers, as recorded in the header of encrypted files
+const (
+	CipherAESGCM            = "aes-256-gcm"
+	CipherChaCha20Poly1305  = "chacha20-poly1305"
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+)
+
+// DefaultCipher is the cipher used to encrypt new credentials
+var DefaultCipher = CipherAESGCM
+
+// Cipher is an authenticated encryption algorithm with associated data, keyed with a 32 byte key
+type Cipher interface {
+	// ID is the identifier of the cipher which is recorded in the header of encrypted files
+	ID() string
+
+	// NonceSize is the size of the nonce that must be passed to Seal and Open
+	NonceSize() int
+
+	// Seal encrypts and authenticates plaintext, authenticates additionalData and returns the ciphertext
+	Seal(key, nonce, plaintext, additionalData []byte) ([]byte, error)
+
+	// Open authenticates and decrypts ciphertext and authenticates additionalData
+	Open(key, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+var (
+	ciphersMu sync.RWMutex
+	ciphers   = map[string]Cipher{}
+)
+
+// RegisterCipher makes a cipher available for encrypting and decrypting credentials.
+// It returns an error if a cipher with the same ID is already registered
+func RegisterCipher(c Cipher) error {
+	ciphersMu.Lock()
+	defer ciphersMu.Unlock()
+	if _, ok := ciphers[c.ID()]; ok {
+		return fmt.Errorf("cipher %q is already registered", c.ID())
+	}
+	ciphers[c.ID()] = c
+	return nil
+}
+
+// Ciphers returns the IDs of the registered ciphers
+func Ciphers() []string {
+	ciphersMu.RLock()
+	defer ciphersMu.RUnlock()
+	var ids []string
+	for id := range ciphers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// lookupCipher returns the registered cipher with the given ID
+func lookupCipher(id string) (Cipher, error) {
+	ciphersMu.RLock()
+	c, ok := ciphers[id]
+	ciphersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher %q", id)
+	}
+	return c, nil
+}
+
+func init() {
+	RegisterCipher(aeadCipher{id: CipherAESGCM, nonceSize: 12, new: newAESGCM})
+	RegisterCipher(aeadCipher{id: CipherChaCha20Poly1305, nonceSize: chacha20poly1305.NonceSize, new: chacha20poly1305.New})
+	RegisterCipher(aeadCipher{id: CipherXChaCha20Poly1305, nonceSize: chacha20poly1305.NonceSizeX, new: chacha20poly1305.NewX})
+}
+
+// aeadCipher adapts a cipher.AEAD constructor to the Cipher interface
+type aeadCipher struct {
+	id        string
+	nonceSize int
+	new       func(key []byte) (cipher.AEAD, error)
+}
+
+func (c aeadCipher) ID() string {
+	return c.id
+}
+
+func (c aeadCipher) NonceSize() int {
+	return c.nonceSize
+}
+
+func (c aeadCipher) Seal(key, nonce, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := c.new(key)
 	if err != nil {
-		return
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d for %s", len(nonce), c.id)
 	}
+	return aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
 
-	block, err := aes.NewCipher(hKey)
+func (c aeadCipher) Open(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := c.new(key)
 	if err != nil {
-		return
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d for %s", len(nonce), c.id)
 	}
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
 
-	nonce = make([]byte, 12)
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-		return
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
 	}
+	return cipher.NewGCM(block)
+}
 
-	aesgcm, err := cipher.NewGCM(block)
+// sealWith encrypts the plaintext with the given cipher and hex encoded key under a random nonce
+func sealWith(c Cipher, key string, fileData, additionalData []byte) (nonce []byte, ciphertext []byte, err error) {
+	hKey, err := hex.DecodeString(key)
 	if err != nil {
 		return
 	}
 
-	ciphertext = aesgcm.Seal(nil, nonce, fileData, nil)
+	nonce = make([]byte, c.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	ciphertext, err = c.Seal(hKey, nonce, fileData, additionalData)
 	return
 }
 
-func decrypt(key string, nonce, text []byte) (plaintext []byte, err error) {
+// openWith decrypts the ciphertext with the given cipher and hex encoded key
+func openWith(c Cipher, key string, nonce, text, additionalData []byte) (plaintext []byte, err error) {
 	hKey, err := hex.DecodeString(key)
 	if err != nil {
 		return
 	}
+	return c.Open(hKey, nonce, text, additionalData)
+}
 
-	block, err := aes.NewCipher(hKey)
+// encrypt encrypts the given plaintext with the given key using AES-256-GCM and returns the ciphertext.
+// additionalData is authenticated but not encrypted, it must be passed unchanged to decrypt
+func encrypt(key string, fileData, additionalData []byte) (nonce []byte, ciphertext []byte, err error) {
+	c, err := lookupCipher(CipherAESGCM)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
+	return sealWith(c, key, fileData, additionalData)
+}
 
-	aesgcm, err := cipher.NewGCM(block)
+// decrypt decrypts the given ciphertext with the given key and authenticates the additional data
+func decrypt(key string, nonce, text, additionalData []byte) (plaintext []byte, err error) {
+	c, err := lookupCipher(CipherAESGCM)
 	if err != nil {
-		return
+		return nil, err
 	}
-
-	plaintext, err = aesgcm.Open(nil, nonce, text, nil)
-	return
+	return openWith(c, key, nonce, text, additionalData)
 }
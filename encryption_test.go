@@ -7,14 +7,15 @@ import (
 
 func TestEncryption(t *testing.T) {
 
-	key := generateKey()
+	key := testKey(t)
 	fileText := []byte("mytestfiletext")
-	nonce, cipherText, err := encrypt(key, fileText)
+	ad := []byte("additional data")
+	nonce, cipherText, err := encrypt(key, fileText, ad)
 	if err != nil {
 		t.Errorf("Unable to encrypt file; got error %v", err)
 	}
 
-	plaintext, err := decrypt(key, nonce, cipherText)
+	plaintext, err := decrypt(key, nonce, cipherText, ad)
 	if err != nil {
 		t.Errorf("Unable to decrypt file; got error %v", err)
 	}
@@ -24,9 +25,79 @@ func TestEncryption(t *testing.T) {
 	}
 
 	// decrypting with an incorrect key
-	_, err = decrypt(generateKey(), nonce, cipherText)
+	_, err = decrypt(testKey(t), nonce, cipherText, ad)
 	if err == nil {
 		t.Errorf("Expected ciphertext not to be decryptable using an incorrect key")
 	}
 
+	// decrypting with different additional data
+	_, err = decrypt(key, nonce, cipherText, []byte("other data"))
+	if err == nil {
+		t.Errorf("Expected ciphertext not to be decryptable using different additional data")
+	}
+
+}
+
+func TestCiphers(t *testing.T) {
+	key := testKey(t)
+	fileText := []byte("mytestfiletext")
+	ad := []byte("additional data")
+
+	for _, id := range []string{CipherAESGCM, CipherChaCha20Poly1305, CipherXChaCha20Poly1305} {
+		t.Run(id, func(t *testing.T) {
+			c, err := lookupCipher(id)
+			if err != nil {
+				t.Fatalf("Expected cipher to be registered; got error %v", err)
+			}
+
+			nonce, cipherText, err := sealWith(c, key, fileText, ad)
+			if err != nil {
+				t.Fatalf("Unable to encrypt file; got error %v", err)
+			}
+			if len(nonce) != c.NonceSize() {
+				t.Errorf("Expected nonce of %d bytes, got %d", c.NonceSize(), len(nonce))
+			}
+
+			plaintext, err := openWith(c, key, nonce, cipherText, ad)
+			if err != nil {
+				t.Fatalf("Unable to decrypt file; got error %v", err)
+			}
+			if !bytes.Equal(fileText, plaintext) {
+				t.Errorf("Expected fileText to be equal to plaintext, got %s and %s", fileText, plaintext)
+			}
+
+			_, err = openWith(c, testKey(t), nonce, cipherText, ad)
+			if err == nil {
+				t.Errorf("Expected ciphertext not to be decryptable using an incorrect key")
+			}
+
+			_, err = openWith(c, key, nonce, cipherText, []byte("other data"))
+			if err == nil {
+				t.Errorf("Expected ciphertext not to be decryptable using different additional data")
+			}
+		})
+	}
+
+	_, err := lookupCipher("rot13")
+	if err == nil {
+		t.Errorf("Expected unknown cipher to be rejected")
+	}
+}
+
+func TestRegisterCipher(t *testing.T) {
+	err := RegisterCipher(aeadCipher{id: CipherAESGCM, nonceSize: 12, new: newAESGCM})
+	if err == nil {
+		t.Errorf("Expected registering a built-in cipher ID to fail")
+	}
+
+	id := "test-aes-256-gcm"
+	if err := RegisterCipher(aeadCipher{id: id, nonceSize: 12, new: newAESGCM}); err != nil {
+		t.Fatalf("Expected cipher to be registered; got error %v", err)
+	}
+	if _, err := lookupCipher(id); err != nil {
+		t.Errorf("Expected registered cipher to be found; got error %v", err)
+	}
+	if err := RegisterCipher(aeadCipher{id: id, nonceSize: 12, new: newAESGCM}); err == nil {
+		t.Errorf("Expected registering a cipher twice to fail")
+	}
 }
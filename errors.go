@@ -0,0 +1,48 @@
+package sicher
+
+import "errors"
+
+// errors returned when the credentials cannot be loaded. The returned errors describe the cause
+// and match one of these with errors.Is
+var (
+	// ErrKeyNotFound is returned when no key, passphrase or identity is available to open the credentials
+	ErrKeyNotFound = errors.New("encryption key not found")
+
+	// ErrCredentialsNotFound is returned when the encrypted credentials file does not exist
+	ErrCredentialsNotFound = errors.New("encrypted credentials not found")
+
+	// ErrDecryptionFailed is returned when the credentials cannot be decrypted with the key,
+	// because it is the wrong key or the credentials were tampered with
+	ErrDecryptionFailed = errors.New("decryption failed")
+
+	// ErrCorruptFile is returned when the encrypted credentials file cannot be decoded
+	ErrCorruptFile = errors.New("encrypted credentials file is corrupt")
+)
+
+// sentinelError is an error which matches a sentinel error with errors.Is, keeping its own message
+type sentinelError struct {
+	err      error
+	sentinel error
+}
+
+func (e *sentinelError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sentinelError) Unwrap() error {
+	return e.err
+}
+
+func (e *sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+// withSentinel returns err as an error matching sentinel, unless it already matches one of the sentinel errors
+func withSentinel(sentinel, err error) error {
+	for _, s := range []error{ErrKeyNotFound, ErrCredentialsNotFound, ErrDecryptionFailed, ErrCorruptFile} {
+		if errors.Is(err, s) {
+			return err
+		}
+	}
+	return &sentinelError{err: err, sentinel: sentinel}
+}
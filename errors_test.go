@@ -0,0 +1,105 @@
+package sicher
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestLoadEnvErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *Sicher)
+		err   error
+	}{
+		{
+			name:  "missing credentials",
+			setup: func(t *testing.T, s *Sicher) { os.Remove(s.Path + s.Environment + ".enc") },
+			err:   ErrCredentialsNotFound,
+		},
+		{
+			name:  "missing key",
+			setup: func(t *testing.T, s *Sicher) { os.Remove(s.Path + s.Environment + ".key") },
+			err:   ErrKeyNotFound,
+		},
+		{
+			name: "wrong key",
+			setup: func(t *testing.T, s *Sicher) {
+				os.WriteFile(s.Path+s.Environment+".key", []byte(testKey(t)), 0600)
+			},
+			err: ErrDecryptionFailed,
+		},
+		{
+			name: "key which is not a recipient",
+			setup: func(t *testing.T, s *Sicher) {
+				if err := s.AddRecipient(testKey(t)); err != nil {
+					t.Fatalf("Unable to add recipient; got error %v", err)
+				}
+				os.WriteFile(s.Path+s.Environment+".key", []byte(testKey(t)), 0600)
+			},
+			err: ErrDecryptionFailed,
+		},
+		{
+			name: "missing key of a recipient",
+			setup: func(t *testing.T, s *Sicher) {
+				if err := s.AddRecipient(testKey(t)); err != nil {
+					t.Fatalf("Unable to add recipient; got error %v", err)
+				}
+				os.Remove(s.Path + s.Environment + ".key")
+			},
+			err: ErrKeyNotFound,
+		},
+		{
+			name: "corrupt file",
+			setup: func(t *testing.T, s *Sicher) {
+				os.WriteFile(s.Path+s.Environment+".enc", []byte("sicher-enc/2\ncipher: aes-256-gcm\nnonce: zz\n\nzz"), 0600)
+			},
+			err: ErrCorruptFile,
+		},
+		{
+			name: "tampered file",
+			setup: func(t *testing.T, s *Sicher) {
+				enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+				tampered := strings.Replace(string(enc), "env: testenv", "env: prod", 1)
+				os.WriteFile(s.Path+s.Environment+".enc", []byte(tampered), 0600)
+			},
+			err: ErrDecryptionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := writeCredentials(t, DOTENV, "PORT=8080\n")
+			tt.setup(t, s)
+			// no other key provider may supply the key
+			t.Setenv(keyProviderEnv, "")
+			t.Setenv(keyringEnv, "")
+			oldLookPath := lookPath
+			defer func() { lookPath = oldLookPath }()
+			lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+
+			var cfg map[string]string
+			err := s.LoadEnv("", &cfg)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected error matching %v; got %v", tt.err, err)
+			}
+			if cfg != nil {
+				t.Errorf("Expected config to be left empty; got %v", cfg)
+			}
+		})
+	}
+}
+
+func TestWithSentinel(t *testing.T) {
+	err := withSentinel(ErrKeyNotFound, errors.New("no key file"))
+	if !errors.Is(err, ErrKeyNotFound) || err.Error() != "no key file" {
+		t.Errorf("Expected error matching ErrKeyNotFound with its own message; got %v", err)
+	}
+
+	// errors matching a sentinel error keep it
+	if !errors.Is(withSentinel(ErrDecryptionFailed, err), ErrKeyNotFound) {
+		t.Errorf("Expected error to keep matching ErrKeyNotFound")
+	}
+}
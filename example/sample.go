@@ -33,8 +33,12 @@ func LoadConfigMap() {
 	cfg := make(map[string]string)
 
 	s := sicher.New("dev", ".")
-	s.SetEnvStyle("yaml") // default is dotenv
-	err := s.LoadEnv("", &cfg)
+	err := s.SetEnvStyle("yaml") // default is dotenv
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	err = s.LoadEnv("", &cfg)
 	if err != nil {
 		fmt.Println(err)
 		return
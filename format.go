@@ -0,0 +1,281 @@
+package sicher
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fileMagic is the prefix of the first line of every versioned credentials file.
+// It is followed by the format version, e.g. "sicher-enc/1".
+var fileMagic = "sicher-enc/"
+
+// formatVersion is the version of the file format written by this package.
+// Version 0 denotes the legacy "hex(ciphertext)==--==hex(nonce)" files, which are still readable.
+// Version 1 files carry a header but do not authenticate it.
+// Since version 2, the environment name and env style are bound to the ciphertext as associated data.
+// Since version 3, unquoted dotenv values expand $KEY references, which older files took literally.
+const formatVersion = 3
+
+// minBoundVersion is the first format version which binds the file metadata as associated data
+const minBoundVersion = 2
+
+// minExpandVersion is the first format version whose unquoted dotenv values expand $KEY references
+const minExpandVersion = 3
+
+// envelope is the decoded representation of an encrypted credentials file
+type envelope struct {
+	// version is the format version of the file. 0 means a legacy file without a header
+	version int
+
+	// cipher is the identifier of the algorithm used to encrypt the credentials
+	cipher string
+
+	// keyID is the fingerprint of the key the credentials were encrypted with.
+	// It is empty for legacy files
+	keyID string
+
+	// environment and style record the environment and env style the credentials were encrypted for.
+	// They are empty before version 2
+	environment string
+	style       EnvStyle
+
+	// kdf holds the key derivation parameters of passphrase protected credentials, nil otherwise
+	kdf *kdfParams
+
+	// recipients hold the data key wrapped for every recipient of the credentials.
+	// If empty, the credentials are encrypted directly with the key of the environment
+	recipients []*recipient
+
+	// pending holds secrets added with the public keys of X25519 recipients, see AddSecret
+	pending [][]byte
+
+	nonce      []byte
+	ciphertext []byte
+}
+
+// encode serializes the envelope into the versioned file format.
+// The file consists of the magic line, a list of "name: value" header fields,
+// an empty line and the hex encoded ciphertext.
+func (e *envelope) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s%d\n", fileMagic, e.version)
+	fmt.Fprintf(&b, "env: %s\n", e.environment)
+	fmt.Fprintf(&b, "style: %s\n", e.style)
+	fmt.Fprintf(&b, "cipher: %s\n", e.cipher)
+	fmt.Fprintf(&b, "key-id: %s\n", e.keyID)
+	if e.kdf != nil {
+		fmt.Fprintf(&b, "kdf: %s\n", e.kdf.algorithm)
+		fmt.Fprintf(&b, "kdf-salt: %x\n", e.kdf.salt)
+		fmt.Fprintf(&b, "kdf-params: %s\n", e.kdf.encodeParams())
+	}
+	for _, r := range e.recipients {
+		fmt.Fprintf(&b, "recipient: %s\n", r.encode())
+	}
+	for _, p := range e.pending {
+		fmt.Fprintf(&b, "pending: %x\n", p)
+	}
+	fmt.Fprintf(&b, "nonce: %x\n", e.nonce)
+	fmt.Fprintf(&b, "\n%x\n", e.ciphertext)
+	return b.Bytes()
+}
+
+// isVersioned reports whether the content of a credentials file starts with the format header
+func isVersioned(encFile string) bool {
+	return strings.HasPrefix(encFile, fileMagic)
+}
+
+// decodeVersioned parses a credentials file written in the versioned format
+func decodeVersioned(encFile string) (*envelope, error) {
+	sc := bufio.NewScanner(strings.NewReader(encFile))
+	sc.Buffer(make([]byte, 0, 64*1024), len(encFile)+1)
+
+	sc.Scan()
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(sc.Text()), fileMagic))
+	if err != nil {
+		return nil, fmt.Errorf("invalid format version: %s", sc.Text())
+	}
+	if version < 1 || version > formatVersion {
+		return nil, fmt.Errorf("unsupported format version %d, upgrade sicher to read this file", version)
+	}
+
+	e := &envelope{version: version}
+
+	// header fields are terminated by an empty line
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			break
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header line %q", line)
+		}
+		value := strings.TrimSpace(kv[1])
+
+		switch strings.TrimSpace(kv[0]) {
+		case "env":
+			e.environment = value
+		case "style":
+			e.style = EnvStyle(value)
+		case "cipher":
+			e.cipher = value
+		case "key-id":
+			e.keyID = value
+		case "kdf":
+			e.kdfParams().algorithm = value
+		case "kdf-salt":
+			e.kdfParams().salt, err = hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid kdf salt: %s", err)
+			}
+		case "kdf-params":
+			if err = e.kdfParams().decodeParams(value); err != nil {
+				return nil, err
+			}
+		case "recipient":
+			r, err := decodeRecipient(value)
+			if err != nil {
+				return nil, err
+			}
+			e.recipients = append(e.recipients, r)
+		case "pending":
+			p, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pending secret: %s", err)
+			}
+			e.pending = append(e.pending, p)
+		case "nonce":
+			e.nonce, err = hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid nonce: %s", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown header field %q", kv[0])
+		}
+	}
+
+	var body strings.Builder
+	for sc.Scan() {
+		body.WriteString(strings.TrimSpace(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	e.ciphertext, err = hex.DecodeString(body.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid ciphertext: %s", err)
+	}
+
+	if e.cipher == "" || e.nonce == nil || len(e.ciphertext) == 0 {
+		return nil, errors.New("invalid credentials: incomplete header")
+	}
+	if e.kdf != nil {
+		if err = e.kdf.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// kdfParams returns the key derivation parameters of the envelope, allocating them if needed
+func (e *envelope) kdfParams() *kdfParams {
+	if e.kdf == nil {
+		e.kdf = &kdfParams{}
+	}
+	return e.kdf
+}
+
+// keyFingerprint returns a short identifier of the given hex encoded key.
+// It is stored in the file header to detect a mismatched key before decrypting.
+func keyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(key))))
+	return hex.EncodeToString(sum[:8])
+}
+
+// canonicalStyle maps equivalent env styles onto a single name, so that e.g.
+// credentials encrypted with the yaml style can be read with the yml style
+func canonicalStyle(style EnvStyle) EnvStyle {
+	if style == YML {
+		return YAML
+	}
+	return style
+}
+
+// associatedData returns the metadata which is authenticated along with the credentials.
+// Binding the environment and style prevents credential files from being swapped or renamed unnoticed
+func associatedData(version int, environment string, style EnvStyle) []byte {
+	if version < minBoundVersion {
+		return nil
+	}
+	return []byte(fmt.Sprintf("%s%d\nenv=%s\nstyle=%s", fileMagic, version, environment, canonicalStyle(style)))
+}
+
+// sealFile encrypts the plaintext with the key and returns the encoded credentials file.
+// The environment, style, cipher and key derivation parameters are taken from the envelope e,
+// which is filled with the new nonce and ciphertext
+func sealFile(key string, plaintext []byte, e *envelope) ([]byte, error) {
+	e.version = formatVersion
+	e.style = canonicalStyle(e.style)
+	if e.cipher == "" {
+		e.cipher = DefaultCipher
+	}
+
+	c, err := lookupCipher(e.cipher)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, ciphertext, err := sealWith(c, key, plaintext, associatedData(e.version, e.environment, e.style))
+	if err != nil {
+		return nil, err
+	}
+
+	e.keyID = keyFingerprint(key)
+	e.nonce = nonce
+	e.ciphertext = ciphertext
+	return e.encode(), nil
+}
+
+// openFile decrypts the credentials held by the envelope with the given key and the cipher recorded in it.
+// The environment and style the caller expects are authenticated against the ones the file was sealed with.
+// Unquoted dotenv values with a $ of files older than minExpandVersion are quoted, so that they keep their literal value
+func openFile(key string, e *envelope, environment string, style EnvStyle) ([]byte, error) {
+	c, err := lookupCipher(e.cipher)
+	if err != nil {
+		return nil, err
+	}
+	if e.keyID != "" && e.keyID != keyFingerprint(key) {
+		if e.kdf != nil {
+			return nil, errors.New("incorrect passphrase")
+		}
+		return nil, errors.New("the key does not match the key the credentials were encrypted with")
+	}
+
+	// the header values are only used for a friendlier error message,
+	// the authentication below relies on the expected values
+	if e.version >= minBoundVersion {
+		if e.environment != environment {
+			return nil, fmt.Errorf("credentials were encrypted for the %q environment, not %q", e.environment, environment)
+		}
+		if e.style != canonicalStyle(style) {
+			return nil, fmt.Errorf("credentials were encrypted with the %q style, not %q", e.style, style)
+		}
+	}
+
+	plaintext, err := openWith(c, key, e.nonce, e.ciphertext, associatedData(e.version, environment, style))
+	if err != nil {
+		return nil, fmt.Errorf("%s: the file may have been tampered with", err)
+	}
+	if e.version < minExpandVersion && canonicalStyle(style) == DOTENV {
+		plaintext = quoteLiteralValues(plaintext)
+	}
+	return plaintext, nil
+}
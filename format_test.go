@@ -0,0 +1,154 @@
+package sicher
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSealAndOpenFile(t *testing.T) {
+	key := testKey(t)
+	plaintext := []byte("PORT=8080\n")
+
+	sealed, err := sealFile(key, plaintext, &envelope{environment: "dev", style: DOTENV})
+	if err != nil {
+		t.Fatalf("Unable to seal file; got error %v", err)
+	}
+
+	if !strings.HasPrefix(string(sealed), fmt.Sprintf("%s%d\n", fileMagic, formatVersion)) {
+		t.Errorf("Expected sealed file to start with the format header, got %s", sealed)
+	}
+
+	e, err := decodeFile(string(sealed))
+	if err != nil {
+		t.Fatalf("Unable to decode sealed file; got error %v", err)
+	}
+
+	if e.version != formatVersion || e.cipher != CipherAESGCM || e.keyID != keyFingerprint(key) {
+		t.Errorf("Unexpected header values %d, %s, %s", e.version, e.cipher, e.keyID)
+	}
+
+	opened, err := openFile(key, e, "dev", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to open file; got error %v", err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %s, got %s", plaintext, opened)
+	}
+
+	_, err = openFile(testKey(t), e, "dev", DOTENV)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Expected key mismatch error, got %v", err)
+	}
+}
+
+func TestDecodeLegacyFile(t *testing.T) {
+	key := testKey(t)
+	plaintext := []byte("PORT=8080\n")
+
+	nonce, ciphertext, err := encrypt(key, plaintext, nil)
+	if err != nil {
+		t.Fatalf("Unable to encrypt; got error %v", err)
+	}
+
+	e, err := decodeFile(fmt.Sprintf("%x%s%x", ciphertext, delimiter, nonce))
+	if err != nil {
+		t.Fatalf("Unable to decode legacy file; got error %v", err)
+	}
+
+	if e.version != 0 || e.keyID != "" {
+		t.Errorf("Expected legacy file to have version 0 and no key id, got %d and %s", e.version, e.keyID)
+	}
+
+	opened, err := openFile(key, e, "dev", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to open legacy file; got error %v", err)
+	}
+
+	if !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %s, got %s", plaintext, opened)
+	}
+}
+
+func TestOpenFileBindsMetadata(t *testing.T) {
+	key := testKey(t)
+
+	sealed, err := sealFile(key, []byte("PORT=8080\n"), &envelope{environment: "prod", style: YAML})
+	if err != nil {
+		t.Fatalf("Unable to seal file; got error %v", err)
+	}
+
+	e, err := decodeFile(string(sealed))
+	if err != nil {
+		t.Fatalf("Unable to decode sealed file; got error %v", err)
+	}
+
+	if _, err := openFile(key, e, "prod", YML); err != nil {
+		t.Errorf("Expected yml style to open yaml credentials, got error %v", err)
+	}
+
+	if _, err := openFile(key, e, "dev", YAML); err == nil {
+		t.Errorf("Expected credentials of another environment to be rejected")
+	}
+
+	if _, err := openFile(key, e, "prod", DOTENV); err == nil {
+		t.Errorf("Expected credentials of another style to be rejected")
+	}
+
+	// rewriting the header must not allow reading the file in another environment
+	e.environment = "dev"
+	if _, err := openFile(key, e, "dev", YAML); err == nil {
+		t.Errorf("Expected tampered header to fail authentication")
+	}
+}
+
+func TestSealFileWithCipher(t *testing.T) {
+	key := testKey(t)
+	plaintext := []byte("PORT=8080\n")
+
+	sealed, err := sealFile(key, plaintext, &envelope{environment: "dev", style: DOTENV, cipher: CipherXChaCha20Poly1305})
+	if err != nil {
+		t.Fatalf("Unable to seal file; got error %v", err)
+	}
+
+	e, err := decodeFile(string(sealed))
+	if err != nil {
+		t.Fatalf("Unable to decode sealed file; got error %v", err)
+	}
+	if e.cipher != CipherXChaCha20Poly1305 {
+		t.Errorf("Expected cipher %s to be recorded, got %s", CipherXChaCha20Poly1305, e.cipher)
+	}
+
+	opened, err := openFile(key, e, "dev", DOTENV)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Expected %s, got %s and error %v", plaintext, opened, err)
+	}
+
+	e.cipher = "rot13"
+	if _, err := openFile(key, e, "dev", DOTENV); err == nil || !strings.Contains(err.Error(), "unsupported cipher") {
+		t.Errorf("Expected unsupported cipher error, got %v", err)
+	}
+}
+
+func TestDecodeVersionedErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "future version", file: fileMagic + "99\ncipher: aes-256-gcm\n\nab\n"},
+		{name: "invalid version", file: fileMagic + "x\n"},
+		{name: "unknown field", file: fileMagic + "1\nfoo: bar\n\nab\n"},
+		{name: "missing nonce", file: fileMagic + "1\ncipher: aes-256-gcm\n\nab\n"},
+		{name: "invalid ciphertext", file: fileMagic + "1\ncipher: aes-256-gcm\nnonce: ab\n\nxyz\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeFile(tt.file); err == nil {
+				t.Errorf("Expected error decoding %q", tt.file)
+			}
+		})
+	}
+}
@@ -2,6 +2,16 @@ module github.com/dsa0x/sicher
 
 go 1.17
 
-require github.com/juju/fslock v0.0.0-20160525022230-4d5c94c67b4b
+require (
+	filippo.io/age v1.0.0
+	github.com/BurntSushi/toml v1.2.1
+	github.com/juju/fslock v0.0.0-20160525022230-4d5c94c67b4b
+	golang.org/x/crypto v0.11.0
+	golang.org/x/term v0.10.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
-require golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
+require (
+	golang.org/x/sys v0.10.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
@@ -4,15 +4,17 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"regexp"
+	"path/filepath"
 	"strings"
-	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 type EnvStyle string
@@ -21,6 +23,8 @@ const (
 	YAML   EnvStyle = "yaml"
 	YML    EnvStyle = "yml"
 	DOTENV EnvStyle = "dotenv"
+	JSON   EnvStyle = "json"
+	TOML   EnvStyle = "toml"
 )
 
 var envStyleDelim = map[EnvStyle]string{
@@ -33,10 +37,15 @@ var envStyleExt = map[EnvStyle]string{
 	YAML:   "yml",
 	YML:    "yml",
 	DOTENV: "env",
+	JSON:   "json",
+	TOML:   "toml",
 }
 
 var envNameRegex = "^[a-zA-Z0-9_]*$"
 
+// randReader is the source of randomness for key generation
+var randReader io.Reader = rand.Reader
+
 // cleanUpFile removes the given file
 func cleanUpFile(filePath string) {
 	err := os.Remove(filePath)
@@ -45,70 +54,102 @@ func cleanUpFile(filePath string) {
 	}
 }
 
-// decodeFile decodes the encrypted file and returns the decoded file and nonce
-func decodeFile(encFile string) (nonce []byte, fileText []byte, err error) {
+// decodeFile decodes the encrypted file into an envelope.
+// Both the versioned format and legacy delimiter separated files are supported.
+// A nil envelope is returned for an empty file
+func decodeFile(encFile string) (*envelope, error) {
 	if encFile == "" {
-		return nil, nil, nil
+		return nil, nil
+	}
+
+	if isVersioned(encFile) {
+		return decodeVersioned(encFile)
 	}
 
 	resp := strings.Split(encFile, delimiter)
 	if len(resp) < 2 {
-		return nil, nil, errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
-	nonce, err = hex.DecodeString(resp[1])
+	nonce, err := hex.DecodeString(resp[1])
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	fileText, err = hex.DecodeString(resp[0])
+	fileText, err := hex.DecodeString(resp[0])
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	return
+	return &envelope{cipher: CipherAESGCM, nonce: nonce, ciphertext: fileText}, nil
 }
 
-// generateKey generates a random key of 32 bytes and encodes as hex string
-func generateKey() string {
-	timestamp := time.Now().UnixNano()
-	key := sha256.Sum256([]byte(fmt.Sprint(timestamp)))
-	rand.Read(key[16:])
-	return hex.EncodeToString(key[:])
-}
-
-// parseConfig parses the environment variables into a map
-func parseConfig(config []byte, store map[string]string, envType EnvStyle) (err error) {
-
-	delim, ok := envStyleDelim[envType]
-	if !ok {
-		return errors.New("invalid environment type")
+// writeFileAtomic writes data to a temporary file in the directory of filePath
+// and renames it over filePath, so that readers never observe a partially written file
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(f.Name())
 
-	var b bytes.Buffer
-	b.Write(config)
-	sc := bufio.NewScanner(&b)
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filePath)
+}
 
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
-		cfgLine := strings.Split(line, delim)
+// GenerateKey generates a random key of 32 bytes and encodes it as a hex string.
+// The key is suitable for use as a key file or as the SICHER_MASTER_KEY environment variable
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(randReader, key); err != nil {
+		return "", fmt.Errorf("error generating key: %s", err)
+	}
+	return hex.EncodeToString(key), nil
+}
 
-		// ignore commented lines and invalid lines
-		if len(cfgLine) < 2 || canIgnore(line) {
-			continue
+// envLine returns the line assigning value to key in the given env style
+// in the json style, it is an object holding the single entry
+func envLine(style EnvStyle, key, value string) string {
+	entry := map[string]string{key: value}
+
+	// the encoders quote values which would otherwise not be read back unchanged
+	switch style {
+	case YAML, YML:
+		out, err := yaml.Marshal(entry)
+		if err == nil {
+			return string(out)
 		}
-
-		// invalidate keys with invalid characters (only alphanumeric and _)
-		regexpKey := regexp.MustCompile(envNameRegex)
-		if !regexpKey.MatchString(cfgLine[0]) {
-			continue
+	case JSON:
+		out, err := json.MarshalIndent(entry, "", "  ")
+		if err == nil {
+			return string(out) + "\n"
 		}
-
-		store[cfgLine[0]] = strings.Join(cfgLine[1:], delim)
-		if err == io.EOF {
-			return nil
+	case TOML:
+		var b bytes.Buffer
+		if err := toml.NewEncoder(&b).Encode(entry); err == nil {
+			return b.String()
 		}
+	case DOTENV:
+		value = quoteDotenv(value)
 	}
-	return nil
+	return fmt.Sprintf("%s%s%s\n", key, envStyleDelim[style], value)
+}
 
+// parseConfig parses the environment variables into a map. Entries which cannot be parsed are skipped
+func parseConfig(config []byte, store map[string]string, envType EnvStyle) (err error) {
+	return parseConfigMode(config, store, envType, false)
 }
 
 // canIgnore ignores commented lines and empty lines
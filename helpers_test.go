@@ -4,6 +4,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -31,12 +32,53 @@ func TestCleanUpFile(t *testing.T) {
 
 }
 
+// testKey generates a key for tests
+func testKey(t *testing.T) string {
+	t.Helper()
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Unable to generate key; got error %v", err)
+	}
+	return key
+}
+
 func TestGenerateKey(t *testing.T) {
-	key := generateKey()
-	_, err := hex.DecodeString(key)
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Unable to generate key; got error %v", err)
+	}
+
+	b, err := hex.DecodeString(key)
 	if err != nil {
 		t.Errorf("Generated key not a valid hex string")
 	}
+	if len(b) != 32 {
+		t.Errorf("Expected key to be 32 bytes long, got %d", len(b))
+	}
+
+	if other := testKey(t); other == key {
+		t.Errorf("Expected generated keys to differ")
+	}
+}
+
+func TestGenerateKeyError(t *testing.T) {
+	oldReader := randReader
+	defer func() { randReader = oldReader }()
+	randReader = strings.NewReader("short")
+
+	if _, err := GenerateKey(); err == nil {
+		t.Errorf("Expected error when the random source fails")
+	}
+
+	s, encPath, keyPath := setupTest()
+	if err := s.Initialize(os.Stdin); err == nil {
+		t.Errorf("Expected Initialize to return the key generation error")
+	}
+
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
 }
 
 func TestBasicParseConfig(t *testing.T) {
@@ -193,15 +235,15 @@ func TestCanIgnore(t *testing.T) {
 }
 
 func TestDecodeHex(t *testing.T) {
-	_nonce, _text := generateKey(), generateKey()
+	_nonce, _text := testKey(t), testKey(t)
 	hexString := _text + delimiter + _nonce
-	_, _, err := decodeFile(hexString)
+	_, err := decodeFile(hexString)
 	if err != nil {
 		t.Errorf("Unable to decode valid hex string, got error %v", err)
 	}
-	nonce, text, err := decodeFile("invalidhex")
+	e, err := decodeFile("invalidhex")
 	if err == nil {
-		t.Errorf("Expected invalid hex file to not decode, got values %s, %s", nonce, text)
+		t.Errorf("Expected invalid hex file to not decode, got values %x, %x", e.nonce, e.ciphertext)
 	}
 
 }
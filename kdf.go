@@ -0,0 +1,207 @@
+package sicher
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+var passphraseEnv = "SICHER_PASSPHRASE"
+
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+// DefaultKDF is the key derivation function used for new passphrase protected credentials
+var DefaultKDF = KDFScrypt
+
+// readPassword prompts on out and reads a passphrase from a line of in, without echoing it if in is a terminal
+var readPassword = func(in io.Reader, out io.Writer, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
+	if f, ok := in.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		b, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(out)
+		if err != nil {
+			return "", fmt.Errorf("error reading passphrase: %s", err)
+		}
+		return string(b), nil
+	}
+
+	line, err := readLine(in)
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("error reading passphrase: %s", err)
+	}
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// limits on the cost parameters accepted from a file header,
+// so that a crafted file cannot make key derivation exhaust the machine
+const (
+	maxScryptN       = 1 << 22
+	maxScryptR       = 32
+	maxScryptMemory  = 1 << 30 // bytes, 128*n*r*p
+	maxArgon2Memory  = 4 << 20 // KiB
+	maxArgon2Time    = 64
+	maxKDFParallel   = 64
+	kdfSaltSize      = 16
+	derivedKeyLength = 32
+)
+
+// kdfParams describes how the encryption key is derived from a passphrase.
+// They are stored in the header of passphrase protected credential files
+type kdfParams struct {
+	algorithm string
+	salt      []byte
+
+	// scrypt parameters
+	n, r int
+
+	// argon2id parameters, memory is in KiB
+	time, memory uint32
+
+	// parallelism for both algorithms
+	p int
+}
+
+// newKDFParams returns the default parameters of the given algorithm with a random salt
+func newKDFParams(algorithm string) (*kdfParams, error) {
+	k := &kdfParams{algorithm: algorithm, salt: make([]byte, kdfSaltSize)}
+	switch algorithm {
+	case KDFScrypt:
+		k.n, k.r, k.p = 1<<15, 8, 1
+	case KDFArgon2id:
+		k.time, k.memory, k.p = 3, 64*1024, 4
+	default:
+		return nil, fmt.Errorf("invalid key derivation function %q: select one of %s or %s", algorithm, KDFScrypt, KDFArgon2id)
+	}
+
+	if _, err := io.ReadFull(randReader, k.salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %s", err)
+	}
+	return k, nil
+}
+
+// encodeParams returns the cost parameters in the "name=value,..." form of the file header
+func (k *kdfParams) encodeParams() string {
+	if k.algorithm == KDFArgon2id {
+		return fmt.Sprintf("t=%d,m=%d,p=%d", k.time, k.memory, k.p)
+	}
+	return fmt.Sprintf("n=%d,r=%d,p=%d", k.n, k.r, k.p)
+}
+
+// decodeParams parses the cost parameters from the file header
+func (k *kdfParams) decodeParams(params string) error {
+	for _, param := range strings.Split(params, ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid kdf parameter %q", param)
+		}
+		v, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid kdf parameter %q", param)
+		}
+
+		switch kv[0] {
+		case "n":
+			k.n = int(v)
+		case "r":
+			k.r = int(v)
+		case "p":
+			k.p = int(v)
+		case "t":
+			k.time = uint32(v)
+		case "m":
+			k.memory = uint32(v)
+		default:
+			return fmt.Errorf("unknown kdf parameter %q", kv[0])
+		}
+	}
+	return nil
+}
+
+// validate checks that the parameters are usable and within sane limits
+func (k *kdfParams) validate() error {
+	if len(k.salt) == 0 {
+		return errors.New("missing kdf salt")
+	}
+	if k.p < 1 || k.p > maxKDFParallel {
+		return fmt.Errorf("invalid kdf parallelism %d", k.p)
+	}
+
+	switch k.algorithm {
+	case KDFScrypt:
+		if k.n < 2 || k.n > maxScryptN || k.n&(k.n-1) != 0 || k.r < 1 || k.r > maxScryptR ||
+			128*int64(k.n)*int64(k.r)*int64(k.p) > maxScryptMemory {
+			return fmt.Errorf("invalid scrypt parameters %s", k.encodeParams())
+		}
+	case KDFArgon2id:
+		if k.time < 1 || k.time > maxArgon2Time || k.memory < 8*uint32(k.p) || k.memory > maxArgon2Memory {
+			return fmt.Errorf("invalid argon2id parameters %s", k.encodeParams())
+		}
+	default:
+		return fmt.Errorf("unsupported key derivation function %q", k.algorithm)
+	}
+	return nil
+}
+
+// deriveKey derives the hex encoded encryption key from the passphrase
+func deriveKey(passphrase string, k *kdfParams) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+
+	var key []byte
+	switch k.algorithm {
+	case KDFScrypt:
+		var err error
+		key, err = scrypt.Key([]byte(passphrase), k.salt, k.n, k.r, k.p, derivedKeyLength)
+		if err != nil {
+			return "", err
+		}
+	case KDFArgon2id:
+		key = argon2.IDKey([]byte(passphrase), k.salt, k.time, k.memory, uint8(k.p), derivedKeyLength)
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// getPassphrase returns the passphrase set on the sicher object or in SICHER_PASSPHRASE.
+// If neither is set and interactive is true, the user is prompted for it.
+// confirm asks for the passphrase twice, for protecting new credentials
+func (s *Sicher) getPassphrase(interactive, confirm bool) (string, error) {
+	if s.passphrase != "" {
+		return s.passphrase, nil
+	}
+	if passphrase := os.Getenv(passphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if !interactive {
+		return "", withSentinel(ErrKeyNotFound, fmt.Errorf("credentials (%s.enc) are protected by a passphrase. Provide it through %s", s.Environment, passphraseEnv))
+	}
+
+	passphrase, err := readPassword(s.in(), s.errOut(), "Enter passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm {
+		again, err := readPassword(s.in(), s.errOut(), "Confirm passphrase: ")
+		if err != nil {
+			return "", err
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	return passphrase, nil
+}
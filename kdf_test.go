@@ -0,0 +1,137 @@
+package sicher
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDeriveKey(t *testing.T) {
+	for _, algorithm := range []string{KDFScrypt, KDFArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			k, err := newKDFParams(algorithm)
+			if err != nil {
+				t.Fatalf("Unable to create kdf params; got error %v", err)
+			}
+
+			key, err := deriveKey("correct horse battery staple", k)
+			if err != nil {
+				t.Fatalf("Unable to derive key; got error %v", err)
+			}
+			if len(key) != 64 {
+				t.Errorf("Expected a 32 byte hex encoded key, got %s", key)
+			}
+
+			again, _ := deriveKey("correct horse battery staple", k)
+			if again != key {
+				t.Errorf("Expected the same passphrase and salt to derive the same key")
+			}
+
+			other, _ := deriveKey("another passphrase", k)
+			if other == key {
+				t.Errorf("Expected different passphrases to derive different keys")
+			}
+
+			// parameters survive a round trip through the header
+			decoded := &kdfParams{algorithm: k.algorithm, salt: k.salt}
+			if err := decoded.decodeParams(k.encodeParams()); err != nil {
+				t.Fatalf("Unable to decode kdf params; got error %v", err)
+			}
+			if fromHeader, _ := deriveKey("correct horse battery staple", decoded); fromHeader != key {
+				t.Errorf("Expected decoded parameters to derive the same key")
+			}
+		})
+	}
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	tests := []struct {
+		name   string
+		params *kdfParams
+	}{
+		{name: "unknown algorithm", params: &kdfParams{algorithm: "md5", salt: salt, p: 1}},
+		{name: "missing salt", params: &kdfParams{algorithm: KDFScrypt, n: 1 << 15, r: 8, p: 1}},
+		{name: "scrypt n not a power of two", params: &kdfParams{algorithm: KDFScrypt, salt: salt, n: 1000, r: 8, p: 1}},
+		{name: "scrypt n too large", params: &kdfParams{algorithm: KDFScrypt, salt: salt, n: 1 << 30, r: 8, p: 1}},
+		{name: "scrypt r too large", params: &kdfParams{algorithm: KDFScrypt, salt: salt, n: 1 << 22, r: 65536, p: 1}},
+		{name: "scrypt memory too large", params: &kdfParams{algorithm: KDFScrypt, salt: salt, n: 1 << 20, r: 16, p: 4}},
+		{name: "argon2id memory too large", params: &kdfParams{algorithm: KDFArgon2id, salt: salt, time: 1, memory: 1 << 30, p: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.params.validate(); err == nil {
+				t.Errorf("Expected parameters to be rejected")
+			}
+		})
+	}
+
+	if _, err := newKDFParams("pbkdf2"); err == nil {
+		t.Errorf("Expected unknown key derivation function to be rejected")
+	}
+}
+
+func TestInitializeWithPassphrase(t *testing.T) {
+	oldReadPassword := readPassword
+	defer func() { readPassword = oldReadPassword }()
+	readPassword = func(in io.Reader, out io.Writer, prompt string) (string, error) {
+		return "s3cret passphrase", nil
+	}
+
+	s, encPath, keyPath := setupTest()
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	if err := s.UsePassphrase(KDFArgon2id); err != nil {
+		t.Fatalf("Expected argon2id to be accepted, got %v", err)
+	}
+	if err := s.Initialize(os.Stdin); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file to be created for passphrase protected credentials")
+	}
+
+	enc, _ := os.ReadFile(encPath)
+	if !strings.Contains(string(enc), "kdf: argon2id") {
+		t.Errorf("Expected header to record the key derivation function, got %s", enc)
+	}
+
+	// LoadEnv does not prompt, the passphrase is read from the environment
+	os.Setenv(passphraseEnv, "s3cret passphrase")
+	defer os.Unsetenv(passphraseEnv)
+
+	loader := New(s.Environment, "./example")
+	mp := make(map[string]string)
+	err := loader.LoadEnv("", &mp)
+	if err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be decrypted with the passphrase, got %v, %v", mp, err)
+	}
+
+	wrong := New(s.Environment, "./example")
+	wrong.SetPassphrase("wrong passphrase")
+	enc, _ = os.ReadFile(encPath)
+	e, _ := decodeFile(string(enc))
+	key, err := wrong.encryptionKey(e, false)
+	if err != nil {
+		t.Fatalf("Expected key to be derived, got %v", err)
+	}
+	if _, err := openFile(key, e, s.Environment, s.envStyle); err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("Expected incorrect passphrase error, got %v", err)
+	}
+}
+
+func TestUsePassphraseInvalidKDF(t *testing.T) {
+	s := New("testenv", "")
+	if err := s.UsePassphrase("pbkdf2"); err == nil {
+		t.Errorf("Expected invalid key derivation function to be rejected")
+	}
+	if err := s.UsePassphrase(""); err != nil || s.kdf != DefaultKDF {
+		t.Errorf("Expected default key derivation function to be used, got %s, %v", s.kdf, err)
+	}
+}
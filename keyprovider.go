@@ -0,0 +1,158 @@
+package sicher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// recipientKMS is the type of recipients whose data key is wrapped by an external key provider, like a KMS.
+// The wrapped key is stored in the file and only the provider can unwrap it
+const recipientKMS = "kms"
+
+var keyProviderEnv = "SICHER_KEY_PROVIDER"
+
+// ErrKeyUnavailable is returned by a KeyProvider which has no key for the environment, so that the
+// next provider is consulted. Providers may wrap it, it is matched with errors.Is
+var ErrKeyUnavailable = errors.New("key is not available")
+
+// KeyProvider supplies the key that opens the credentials of an environment.
+// sicher consults SICHER_MASTER_KEY, the key file, the keyring and then the external provider, using the first key found
+type KeyProvider interface {
+	// Name identifies the provider. It is recorded in the credentials along with the keys wrapped by the provider
+	Name() string
+
+	// Key returns the hex encoded key of the environment. wrapped is the data key stored for the provider in
+	// the credentials, or empty. A provider without a key for the environment returns an error matching ErrKeyUnavailable
+	Key(environment, wrapped string) (string, error)
+}
+
+// KeyWrapper is a KeyProvider which never hands out its own key, like a KMS. It generates data keys
+// along with their wrapped form, which is stored in the credentials and unwrapped by Key
+type KeyWrapper interface {
+	KeyProvider
+
+	// GenerateDataKey returns a new hex encoded data key and the data key wrapped by the provider
+	GenerateDataKey(environment string) (key, wrapped string, err error)
+}
+
+// envKeyProvider reads the key from SICHER_MASTER_KEY
+type envKeyProvider struct{}
+
+func (envKeyProvider) Name() string {
+	return masterKey
+}
+
+func (envKeyProvider) Key(environment, wrapped string) (string, error) {
+	key := os.Getenv(masterKey)
+	if key == "" {
+		return "", ErrKeyUnavailable
+	}
+	return key, nil
+}
+
+// fileKeyProvider reads the key from the key file of the environment
+type fileKeyProvider struct {
+	path string
+}
+
+func (p fileKeyProvider) Name() string {
+	return p.path
+}
+
+func (p fileKeyProvider) Key(environment, wrapped string) (string, error) {
+	key, err := os.ReadFile(p.path)
+	if err != nil {
+		return "", ErrKeyUnavailable
+	}
+	return string(key), nil
+}
+
+// SetKeyProvider sets the external key provider, which is consulted after SICHER_MASTER_KEY, the key file and the keyring.
+// If the provider is a KeyWrapper, Initialize encrypts new credentials with a data key wrapped by it.
+// If not set, the provider is configured from SICHER_KEY_PROVIDER
+func (s *Sicher) SetKeyProvider(p KeyProvider) {
+	s.keyProvider = p
+}
+
+// externalProvider returns the external key provider, or nil if none is configured
+func (s *Sicher) externalProvider() (KeyProvider, error) {
+	if s.keyProvider != nil {
+		return s.keyProvider, nil
+	}
+
+	providerURL := os.Getenv(keyProviderEnv)
+	if providerURL == "" {
+		return nil, nil
+	}
+	p, err := NewTransitProvider(providerURL, transitToken())
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", keyProviderEnv, err)
+	}
+	return p, nil
+}
+
+// keyProviders returns the key providers in the order they are consulted
+func (s *Sicher) keyProviders(keyPath string) ([]KeyProvider, error) {
+	providers := []KeyProvider{envKeyProvider{}, fileKeyProvider{path: keyPath}, s.keyring()}
+
+	p, err := s.externalProvider()
+	if err != nil {
+		return nil, err
+	}
+	if p != nil {
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// unwrapKeyKMS returns the data key of the credentials, unwrapped by the external key provider
+func (s *Sicher) unwrapKeyKMS(e *envelope) (string, error) {
+	p, err := s.externalProvider()
+	if err != nil {
+		return "", err
+	}
+	if p == nil {
+		return "", fmt.Errorf("credentials are encrypted with a key provider, configure it through %s", keyProviderEnv)
+	}
+
+	r := e.findRecipient(p.Name())
+	if r == nil || r.kind != recipientKMS {
+		return "", fmt.Errorf("the key provider %s is not a recipient of the credentials", p.Name())
+	}
+
+	key, err := p.Key(s.Environment, string(r.wrapped))
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping data key with %s: %s", p.Name(), err)
+	}
+	return key, nil
+}
+
+// initializeWithProvider creates an encrypted credentials file with a data key generated by the key provider.
+// No key file is created, the data key is unwrapped by the provider whenever the credentials are opened
+func (s *Sicher) initializeWithProvider(p KeyWrapper, confirm func() bool) error {
+	encPath := fmt.Sprintf("%s%s.enc", s.Path, s.Environment)
+	if info, err := os.Stat(encPath); err == nil && info.Size() > 0 && !confirm() {
+		fmt.Fprintln(s.out(), "Exiting. Leaving credentials file unmodified")
+		return nil
+	}
+
+	key, wrapped, err := p.GenerateDataKey(s.Environment)
+	if err != nil {
+		return fmt.Errorf("error generating data key with %s: %s", p.Name(), err)
+	}
+
+	e := s.newEnvelope()
+	e.recipients = []*recipient{{kind: recipientKMS, id: p.Name(), wrapped: []byte(wrapped)}}
+	initFile := []byte(envLine(s.envStyle, "TESTKEY", "loremipsum"))
+	sealed, err := sealFile(key, initFile, e)
+	if err != nil {
+		return fmt.Errorf("error encrypting credentials file: %s", err)
+	}
+
+	err = writeFileAtomic(encPath, sealed, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package sicher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// key stores supported by Initialize
+const (
+	KeyStoreFile    = "file"
+	KeyStoreKeyring = "keyring"
+)
+
+// keyring backends
+const (
+	keyringSecretService = "secret-service"
+	keyringPass          = "pass"
+)
+
+// keyringEnv selects the keyring backend. If empty, the Secret Service is used when secret-tool
+// is installed and a pass password store otherwise
+var keyringEnv = "SICHER_KEYRING"
+
+var lookPath = exec.LookPath
+
+// keyringTimeout limits how long the keyring tools may run, e.g. while gpg waits for a pinentry
+var keyringTimeout = 30 * time.Second
+
+// keyringProvider reads the key of the environment from the OS keyring: the Linux Secret Service,
+// through secret-tool, or a pass-compatible password store. Keys are stored per project path and environment
+type keyringProvider struct {
+	project string
+}
+
+// keyring returns the keyring provider for the project of s
+func (s *Sicher) keyring() keyringProvider {
+	return keyringProvider{project: strings.TrimSuffix(s.Path, "/")}
+}
+
+func (k keyringProvider) Name() string {
+	return "keyring"
+}
+
+// backend returns the keyring backend to use
+func (k keyringProvider) backend() (string, error) {
+	switch backend := os.Getenv(keyringEnv); backend {
+	case keyringSecretService, keyringPass:
+		return backend, nil
+	case "":
+	default:
+		return "", fmt.Errorf("invalid %s %q: select one of %s or %s", keyringEnv, backend, keyringSecretService, keyringPass)
+	}
+
+	if _, err := lookPath("secret-tool"); err == nil {
+		return keyringSecretService, nil
+	}
+	if _, err := lookPath("pass"); err == nil {
+		return keyringPass, nil
+	}
+	return "", errors.New("no keyring is available, install secret-tool (libsecret) or pass")
+}
+
+// passEntry returns the name of the pass entry holding the key of the environment
+func (k keyringProvider) passEntry(environment string) string {
+	return fmt.Sprintf("sicher%s/%s", k.project, environment)
+}
+
+// secretToolAttributes returns the attributes identifying the key of the environment in the Secret Service
+func (k keyringProvider) secretToolAttributes(environment string) []string {
+	return []string{"service", "sicher", "project", k.project, "environment", environment}
+}
+
+func (k keyringProvider) Key(environment, wrapped string) (string, error) {
+	backend, err := k.backend()
+	if err != nil {
+		if os.Getenv(keyringEnv) != "" {
+			return "", err
+		}
+		return "", ErrKeyUnavailable
+	}
+
+	var cmd *exec.Cmd
+	if backend == keyringSecretService {
+		cmd = execCmd("secret-tool", append([]string{"lookup"}, k.secretToolAttributes(environment)...)...)
+	} else {
+		cmd = execCmd("pass", "show", k.passEntry(environment))
+	}
+
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := runKeyringCmd(cmd); err != nil {
+		// secret-tool fails without a message if there is no entry for the environment
+		message := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && (message == "" || strings.Contains(message, "is not in the password store")) {
+			return "", ErrKeyUnavailable
+		}
+		return "", fmt.Errorf("%s %s", err, message)
+	}
+
+	key := strings.TrimSpace(out.String())
+	if key == "" {
+		return "", ErrKeyUnavailable
+	}
+	return key, nil
+}
+
+// runKeyringCmd runs a keyring tool, killing it if it does not finish within keyringTimeout
+func runKeyringCmd(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timer := time.NewTimer(keyringTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("%s did not respond within %s", filepath.Base(cmd.Path), keyringTimeout)
+	}
+}
+
+// store saves the key of the environment in the keyring, replacing an existing one
+func (k keyringProvider) store(environment, key string) error {
+	backend, err := k.backend()
+	if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if backend == keyringSecretService {
+		label := fmt.Sprintf("sicher %s key of %s", environment, k.project)
+		cmd = execCmd("secret-tool", append([]string{"store", "--label", label}, k.secretToolAttributes(environment)...)...)
+	} else {
+		cmd = execCmd("pass", "insert", "--multiline", "--force", k.passEntry(environment))
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(key + "\n")
+	cmd.Stderr = &stderr
+	if err := runKeyringCmd(cmd); err != nil {
+		return fmt.Errorf("error saving key in the keyring: %s %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// SetKeyStore sets where Initialize saves the key of new credentials: KeyStoreFile, the default,
+// creates {environment}.key and KeyStoreKeyring saves the key in the OS keyring
+func (s *Sicher) SetKeyStore(store string) error {
+	if store != KeyStoreFile && store != KeyStoreKeyring {
+		return fmt.Errorf("invalid key store %q: select one of %s or %s", store, KeyStoreFile, KeyStoreKeyring)
+	}
+	s.keyStore = store
+	return nil
+}
+
+// initializeWithKeyring creates an encrypted credentials file whose key is saved in the keyring instead of a key file
+func (s *Sicher) initializeWithKeyring(confirm func() bool) error {
+	k := s.keyring()
+	if _, err := k.backend(); err != nil {
+		return err
+	}
+
+	encPath := fmt.Sprintf("%s%s.enc", s.Path, s.Environment)
+	info, statErr := os.Stat(encPath)
+	encExists := statErr == nil && info.Size() > 0
+
+	// as with key files, an existing key is kept along with the credentials it encrypts
+	_, err := k.Key(s.Environment, "")
+	if err == nil && encExists {
+		return nil
+	}
+	if err != nil && !errors.Is(err, ErrKeyUnavailable) {
+		return fmt.Errorf("error reading key from the keyring: %s", err)
+	}
+	if encExists && !confirm() {
+		fmt.Fprintln(s.out(), "Exiting. Leaving credentials file unmodified")
+		return nil
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	initFile := []byte(envLine(s.envStyle, "TESTKEY", "loremipsum"))
+	sealed, err := sealFile(key, initFile, s.newEnvelope())
+	if err != nil {
+		return fmt.Errorf("error encrypting credentials file: %s", err)
+	}
+
+	// the key is saved first, so that the credentials are never left without it
+	if err = k.store(s.Environment, key); err != nil {
+		return err
+	}
+	err = writeFileAtomic(encPath, sealed, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+	return nil
+}
+
+// ImportKey moves the key file of the environment into the keyring. The key file is
+// removed once the key has been read back from the keyring
+func (s *Sicher) ImportKey() error {
+	keyPath := fmt.Sprintf("%s%s.key", s.Path, s.Environment)
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("key file (%s.key) is not available: %s", s.Environment, err)
+	}
+
+	k := s.keyring()
+	if err = k.store(s.Environment, strings.TrimSpace(string(key))); err != nil {
+		return err
+	}
+	stored, err := k.Key(s.Environment, "")
+	if err != nil || stored != strings.TrimSpace(string(key)) {
+		return errors.New("the key could not be read back from the keyring, keeping the key file")
+	}
+
+	if err = os.Remove(keyPath); err != nil {
+		return fmt.Errorf("error removing key file: %s", err)
+	}
+	return nil
+}
+
+// ExportKey writes the key of the environment from the keyring to the key file.
+// The key stays in the keyring
+func (s *Sicher) ExportKey() error {
+	key, err := s.keyring().Key(s.Environment, "")
+	if errors.Is(err, ErrKeyUnavailable) {
+		return fmt.Errorf("the keyring has no key for the %s environment", s.Environment)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading key from the keyring: %s", err)
+	}
+
+	keyPath := fmt.Sprintf("%s%s.key", s.Path, s.Environment)
+	if _, err := os.Stat(keyPath); err == nil {
+		return fmt.Errorf("key file (%s.key) already exists", s.Environment)
+	}
+	err = writeFileAtomic(keyPath, []byte(key), 0600)
+	if err != nil {
+		return fmt.Errorf("error saving key file: %s", err)
+	}
+
+	if s.gitignorePath != "" {
+		err = addToGitignore(fmt.Sprintf("%s.key", s.Environment), s.gitignorePath)
+		if err != nil {
+			return fmt.Errorf("error adding key file to gitignore: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package sicher
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePass replaces pass with a password store in a temporary directory
+func fakePass(t *testing.T) string {
+	t.Helper()
+	store := t.TempDir()
+	t.Setenv(keyringEnv, keyringPass)
+
+	oldExecCmd := execCmd
+	t.Cleanup(func() { execCmd = oldExecCmd })
+	execCmd = func(cmd string, args ...string) *exec.Cmd {
+		if cmd != "pass" {
+			t.Errorf("Expected command to be pass, got %s", cmd)
+		}
+		entry := filepath.Join(store, strings.ReplaceAll(args[len(args)-1], "/", "_"))
+		if args[0] == "insert" {
+			return exec.Command("sh", "-c", `cat > "$1"`, "sh", entry)
+		}
+		return exec.Command("sh", "-c", `cat "$1" 2>/dev/null || { echo "Error: $2 is not in the password store." >&2; exit 1; }`, "sh", entry, args[len(args)-1])
+	}
+	return store
+}
+
+func TestInitializeWithKeyring(t *testing.T) {
+	t.Setenv(masterKey, "")
+	fakePass(t)
+
+	s, encPath, keyPath := setupTest()
+	t.Cleanup(func() { os.Remove(encPath) })
+
+	if err := s.SetKeyStore("vault"); err == nil {
+		t.Errorf("Expected invalid key store to be rejected")
+	}
+	if err := s.SetKeyStore(KeyStoreKeyring); err != nil {
+		t.Fatalf("Expected key store to be valid, got error %v", err)
+	}
+
+	err := s.Initialize(os.Stdin)
+	if err != nil {
+		t.Fatalf("Expected credentials to be initialized, got error %v", err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file to be created")
+	}
+
+	mp := make(map[string]string)
+	err = New(s.Environment, s.Path).LoadEnv("", &mp)
+	if err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be readable with the key from the keyring, got %v, %v", mp, err)
+	}
+}
+
+func TestImportExportKey(t *testing.T) {
+	t.Setenv(masterKey, "")
+	fakePass(t)
+
+	s, encPath, keyPath := setupTest()
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+	key, _ := os.ReadFile(keyPath)
+
+	if err := s.ExportKey(); err == nil {
+		t.Errorf("Expected export to fail without a key in the keyring")
+	}
+
+	if err := s.ImportKey(); err != nil {
+		t.Fatalf("Expected key to be imported, got error %v", err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected key file to be removed after import")
+	}
+
+	stored, err := s.keyring().Key(s.Environment, "")
+	if err != nil || stored != string(key) {
+		t.Errorf("Expected keyring to hold the key, got %q, %v", stored, err)
+	}
+
+	if err := s.ExportKey(); err != nil {
+		t.Fatalf("Expected key to be exported, got error %v", err)
+	}
+	exported, _ := os.ReadFile(keyPath)
+	if string(exported) != string(key) {
+		t.Errorf("Expected exported key %s, got %s", key, exported)
+	}
+	if err := s.ExportKey(); err == nil {
+		t.Errorf("Expected export not to overwrite an existing key file")
+	}
+}
+
+func TestKeyringBackend(t *testing.T) {
+	oldLookPath := lookPath
+	t.Cleanup(func() { lookPath = oldLookPath })
+	k := keyringProvider{project: "/app"}
+
+	t.Setenv(keyringEnv, "kwallet")
+	if _, err := k.backend(); err == nil {
+		t.Errorf("Expected invalid keyring backend to be rejected")
+	}
+
+	t.Setenv(keyringEnv, "")
+	lookPath = func(file string) (string, error) {
+		if file == "pass" {
+			return "/usr/bin/pass", nil
+		}
+		return "", exec.ErrNotFound
+	}
+	if backend, err := k.backend(); err != nil || backend != keyringPass {
+		t.Errorf("Expected pass to be used when secret-tool is missing, got %s, %v", backend, err)
+	}
+
+	lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+	if _, err := k.backend(); err == nil {
+		t.Errorf("Expected an error without any keyring")
+	}
+	if _, err := k.Key("dev", ""); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("Expected key to be unavailable without any keyring, got %v", err)
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	fakePass(t)
+	k := keyringProvider{project: "/app"}
+
+	if _, err := k.Key("dev", ""); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("Expected a missing entry to be unavailable, got %v", err)
+	}
+
+	oldExecCmd, oldTimeout := execCmd, keyringTimeout
+	t.Cleanup(func() { execCmd, keyringTimeout = oldExecCmd, oldTimeout })
+
+	execCmd = func(cmd string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "echo 'gpg: decryption failed: No secret key' >&2; exit 2")
+	}
+	if _, err := k.Key("dev", ""); err == nil || errors.Is(err, ErrKeyUnavailable) || !strings.Contains(err.Error(), "No secret key") {
+		t.Errorf("Expected the error of the keyring, got %v", err)
+	}
+
+	// a keyring waiting for input is stopped
+	keyringTimeout = 50 * time.Millisecond
+	execCmd = func(cmd string, args ...string) *exec.Cmd {
+		return exec.Command("sleep", "10")
+	}
+	start := time.Now()
+	if _, err := k.Key("dev", ""); err == nil || !strings.Contains(err.Error(), "did not respond") {
+		t.Errorf("Expected the keyring to time out, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the keyring command to be killed")
+	}
+}
+
+func TestRotateKeyWithKeyring(t *testing.T) {
+	t.Setenv(masterKey, "")
+	store := fakePass(t)
+
+	s, encPath, keyPath := setupTest()
+	t.Cleanup(func() { os.Remove(encPath) })
+	s.SetKeyStore(KeyStoreKeyring)
+	if err := s.Initialize(os.Stdin); err != nil {
+		t.Fatalf("Expected credentials to be initialized, got error %v", err)
+	}
+	oldKey, _ := s.keyring().Key(s.Environment, "")
+
+	if err := s.RotateKey(true); err != nil {
+		t.Fatalf("Expected key to be rotated, got error %v", err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file to be created")
+	}
+
+	newKey, err := s.keyring().Key(s.Environment, "")
+	if err != nil || newKey == oldKey {
+		t.Errorf("Expected the keyring to hold the new key, got %v", err)
+	}
+	if backup, _ := s.keyring().Key(s.Environment+".bak", ""); backup != oldKey {
+		t.Errorf("Expected the old key to be kept in the keyring, entries %s", store)
+	}
+
+	mp := make(map[string]string)
+	err = New(s.Environment, s.Path).LoadEnv("", &mp)
+	if err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be readable with the rotated key, got %v, %v", mp, err)
+	}
+}
@@ -0,0 +1,40 @@
+package sicher
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lint checks the decrypted credentials for entries which cannot be parsed, like lines without delimiter
+// or invalid keys, and for keys defined more than once, empty values, trailing whitespace and unquoted
+// dotenv values which expand a $.
+// The problems are returned in the order of their lines
+func (s *Sicher) Lint() ([]LineError, error) {
+	plaintext, err := s.decryptCredentials()
+	if err != nil {
+		return nil, err
+	}
+	return lintCredentials(plaintext, s.envStyle)
+}
+
+// lintCredentials returns the problems of the credentials of the given style
+func lintCredentials(config []byte, style EnvStyle) ([]LineError, error) {
+	p := &configParser{store: make(map[string]string), strict: true}
+	if err := p.parse(config, style); err != nil {
+		return nil, err
+	}
+
+	problems := append(append(append([]LineError{}, p.lines...), p.duplicates...), p.warnings...)
+	for _, e := range p.entries {
+		if e.value == "" {
+			problems = append(problems, LineError{Line: e.line, Key: e.key, Reason: fmt.Sprintf("empty value of %s", e.key)})
+		}
+	}
+
+	for i, line := range strings.Split(strings.ReplaceAll(string(config), "\r\n", "\n"), "\n") {
+		if strings.TrimRight(line, " \t") != line {
+			problems = append(problems, LineError{Line: i + 1, Reason: "trailing whitespace"})
+		}
+	}
+	return sortLines(problems), nil
+}
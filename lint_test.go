@@ -0,0 +1,45 @@
+package sicher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintCredentials(t *testing.T) {
+	config := "PORT=8080 \nHOST localhost\nNAME=\nPORT=9090\nDB_PASS=ab$cd\nLITERAL='ab$cd'\nURL=\"$PORT/api\"\nPRICE=5$"
+	problems, err := lintCredentials([]byte(config), DOTENV)
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	expected := []LineError{
+		{Line: 1, Reason: "trailing whitespace"},
+		{Line: 2, Reason: `missing "=" between key and value`},
+		{Line: 3, Key: "NAME", Reason: "empty value of NAME"},
+		{Line: 4, Key: "PORT", Reason: "duplicate key PORT overrides the value of line 1"},
+		{Line: 5, Key: "DB_PASS", Reason: "unquoted value of DB_PASS expands $: double-quote it to expand, or single-quote it to keep it literal"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected problems %+v; got %+v", expected, problems)
+	}
+}
+
+func TestLint(t *testing.T) {
+	s := writeCredentials(t, YAML, "PORT: 8080\nHOST: localhost\n")
+	problems, err := s.Lint()
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems; got %+v", problems)
+	}
+
+	s = writeCredentials(t, YAML, "PORT: 8080\nPORT: 9090\n")
+	problems, err = s.Lint()
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if len(problems) != 1 || problems[0].Line != 2 {
+		t.Errorf("Expected the duplicate key on line 2; got %+v", problems)
+	}
+}
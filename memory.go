@@ -0,0 +1,101 @@
+package sicher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Seal encrypts plaintext credentials of the given environment and style with key, as generated by
+// GenerateKey, and returns them in the format of {environment}.enc. The file is not written to disk,
+// so it can be embedded, stored in a database or shipped in a container image
+func Seal(key string, plaintext []byte, environment string, style EnvStyle) ([]byte, error) {
+	if err := checkCredentialsParams(environment, style); err != nil {
+		return nil, err
+	}
+	sealed, err := sealFile(key, plaintext, &envelope{environment: environment, style: style})
+	if err != nil {
+		return nil, fmt.Errorf("error encrypting credentials: %s", err)
+	}
+	return sealed, nil
+}
+
+// Open decrypts credentials of the given environment and style, in the format of {environment}.enc, with key.
+// key is the key the credentials were encrypted with or, for credentials with recipients, the key of a recipient.
+// Passphrase protected credentials and credentials with secrets added with public keys, which
+// are not merged yet, cannot be opened with a key and return an error. Unquoted dotenv values with a $ of
+// credentials sealed before format version 3, which took them literally, are returned single-quoted
+func Open(key string, sealed []byte, environment string, style EnvStyle) ([]byte, error) {
+	if err := checkCredentialsParams(environment, style); err != nil {
+		return nil, err
+	}
+
+	e, err := decodeFile(string(sealed))
+	if err != nil {
+		return nil, withSentinel(ErrCorruptFile, fmt.Errorf("error decoding credentials: %s", err))
+	}
+	if e == nil {
+		return nil, withSentinel(ErrCorruptFile, errors.New("error decoding credentials: the credentials are empty"))
+	}
+	if e.kdf != nil {
+		return nil, errors.New("the credentials are protected by a passphrase")
+	}
+	if len(e.pending) > 0 {
+		return nil, errors.New("the credentials contain secrets added with a public key, which need an identity to be read: merge them with sicher edit first")
+	}
+
+	if len(e.recipients) > 0 {
+		key, err = unwrapKey(e, key, environment)
+		if err != nil {
+			return nil, withSentinel(ErrDecryptionFailed, err)
+		}
+	}
+
+	plaintext, err := openFile(key, e, environment, style)
+	if err != nil {
+		return nil, withSentinel(ErrDecryptionFailed, fmt.Errorf("error decrypting credentials: %s", err))
+	}
+	return plaintext, nil
+}
+
+// Encrypt reads plaintext credentials from src, encrypts them like Seal and writes them to dst
+func Encrypt(dst io.Writer, src io.Reader, key, environment string, style EnvStyle) error {
+	plaintext, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("error reading credentials: %s", err)
+	}
+	sealed, err := Seal(key, plaintext, environment, style)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(sealed); err != nil {
+		return fmt.Errorf("error writing encrypted credentials: %s", err)
+	}
+	return nil
+}
+
+// Decrypt reads encrypted credentials from src, decrypts them like Open and writes them to dst
+func Decrypt(dst io.Writer, src io.Reader, key, environment string, style EnvStyle) error {
+	sealed, err := io.ReadAll(src)
+	if err != nil {
+		return fmt.Errorf("error reading encrypted credentials: %s", err)
+	}
+	plaintext, err := Open(key, sealed, environment, style)
+	if err != nil {
+		return err
+	}
+	if _, err = dst.Write(plaintext); err != nil {
+		return fmt.Errorf("error writing credentials: %s", err)
+	}
+	return nil
+}
+
+func checkCredentialsParams(environment string, style EnvStyle) error {
+	if environment == "" {
+		return errors.New("environment not set")
+	}
+	if _, ok := envStyleExt[style]; !ok {
+		return fmt.Errorf("invalid style %q: select one of dotenv, yml, yaml, json or toml", style)
+	}
+	return nil
+}
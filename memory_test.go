@@ -0,0 +1,114 @@
+package sicher
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := testKey(t)
+	sealed, err := Seal(key, []byte("PORT=8080\n"), "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	plaintext, err := Open(key, sealed, "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if string(plaintext) != "PORT=8080\n" {
+		t.Errorf("Expected the credentials to be opened; got %q", plaintext)
+	}
+
+	if _, err = Open(testKey(t), sealed, "prod", DOTENV); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed with another key; got %v", err)
+	}
+	if _, err = Open(key, sealed, "dev", DOTENV); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for another environment; got %v", err)
+	}
+	if _, err = Open(key, []byte("sicher-enc/2\n\nzz"), "prod", DOTENV); !errors.Is(err, ErrCorruptFile) {
+		t.Errorf("Expected ErrCorruptFile; got %v", err)
+	}
+	if _, err = Seal(key, []byte("PORT=8080\n"), "prod", "ini"); err == nil {
+		t.Error("Expected an error for an invalid style")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey(t)
+
+	var sealed bytes.Buffer
+	if err := Encrypt(&sealed, strings.NewReader("PORT: 8080\n"), key, "prod", YAML); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	var plaintext bytes.Buffer
+	if err := Decrypt(&plaintext, &sealed, key, "prod", YAML); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if plaintext.String() != "PORT: 8080\n" {
+		t.Errorf("Expected the credentials to be decrypted; got %q", plaintext.String())
+	}
+}
+
+func TestSealIsCompatibleWithFiles(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "PORT=8080\n")
+	key, _ := os.ReadFile(s.Path + s.Environment + ".key")
+
+	// credentials written by sicher can be opened in memory
+	enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+	plaintext, err := Open(string(key), enc, s.Environment, DOTENV)
+	if err != nil || string(plaintext) != "PORT=8080\n" {
+		t.Errorf("Expected the credentials file to be opened; got %q, %v", plaintext, err)
+	}
+
+	// and credentials sealed in memory can be loaded
+	sealed, err := Seal(string(key), []byte("PORT=9090\n"), s.Environment, DOTENV)
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	os.WriteFile(s.Path+s.Environment+".enc", sealed, 0600)
+
+	var cfg map[string]string
+	if err = s.Decode("", &cfg); err != nil || cfg["PORT"] != "9090" {
+		t.Errorf("Expected the sealed credentials to be loaded; got %v, %v", cfg, err)
+	}
+}
+
+func TestOpenWithRecipientKey(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "PORT=8080\n")
+	recipientKey := testKey(t)
+	if err := s.AddRecipient(recipientKey); err != nil {
+		t.Fatalf("Unable to add recipient; got error %v", err)
+	}
+
+	enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+	plaintext, err := Open(recipientKey, enc, s.Environment, DOTENV)
+	if err != nil || string(plaintext) != "PORT=8080\n" {
+		t.Errorf("Expected the credentials to be opened with the recipient key; got %q, %v", plaintext, err)
+	}
+
+	if _, err = Open(testKey(t), enc, s.Environment, DOTENV); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for a key which is not a recipient; got %v", err)
+	}
+}
+
+func TestOpenWithPendingSecrets(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "PORT=8080\n")
+	key, _ := os.ReadFile(s.Path + s.Environment + ".key")
+	_, publicKey, _ := GenerateIdentity()
+	if err := s.AddRecipient(publicKey); err != nil {
+		t.Fatalf("Unable to add recipient; got error %v", err)
+	}
+	if err := s.AddSecret("TOKEN", "abc"); err != nil {
+		t.Fatalf("Unable to add secret; got error %v", err)
+	}
+
+	enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+	if _, err := Open(string(key), enc, s.Environment, DOTENV); err == nil || !strings.Contains(err.Error(), "added with a public key") {
+		t.Errorf("Expected an error for secrets which cannot be opened with a key; got %v", err)
+	}
+}
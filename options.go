@@ -0,0 +1,143 @@
+package sicher
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Option configures a Sicher created with NewWithOptions
+type Option func(s *Sicher) error
+
+// NewWithOptions creates a Sicher for the "dev" environment of the project in the current directory,
+// configured by opts
+func NewWithOptions(opts ...Option) (*Sicher, error) {
+	s := &Sicher{Environment: defaultEnv, data: make(map[string]string), envStyle: DOTENV}
+	for _, opt := range append([]Option{WithPath(".")}, opts...) {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// WithPath sets the path to the project
+func WithPath(path string) Option {
+	return func(s *Sicher) error {
+		if path == "" {
+			path = "."
+		}
+		path, _ = filepath.Abs(path)
+		s.Path = path + "/"
+		return nil
+	}
+}
+
+// WithEnvironment sets the environment to use
+func WithEnvironment(environment string) Option {
+	return func(s *Sicher) error {
+		if environment == "" {
+			environment = defaultEnv
+		}
+		s.Environment = environment
+		return nil
+	}
+}
+
+// WithEnvStyle sets the style of the decrypted credentials, see SetEnvStyle
+func WithEnvStyle(style EnvStyle) Option {
+	return func(s *Sicher) error {
+		return s.SetEnvStyle(string(style))
+	}
+}
+
+// WithKeyProvider sets the external key provider, see SetKeyProvider
+func WithKeyProvider(p KeyProvider) Option {
+	return func(s *Sicher) error {
+		s.SetKeyProvider(p)
+		return nil
+	}
+}
+
+// WithKeyStore sets where Initialize saves the key of new credentials, see SetKeyStore
+func WithKeyStore(store string) Option {
+	return func(s *Sicher) error {
+		return s.SetKeyStore(store)
+	}
+}
+
+// WithRecipientKeys sets the keys of other symmetric recipients, see SetRecipientKeys
+func WithRecipientKeys(keys ...string) Option {
+	return func(s *Sicher) error {
+		s.SetRecipientKeys(keys...)
+		return nil
+	}
+}
+
+// WithGitignorePath sets the .gitignore file the key file is added to, see SetGitignorePath
+func WithGitignorePath(path string) Option {
+	return func(s *Sicher) error {
+		s.SetGitignorePath(path)
+		return nil
+	}
+}
+
+// WithFS sets the file system LoadEnv, Decode, Export and Lint read {environment}.enc from, e.g. an embed.FS
+// holding the credentials. The key is still read from SICHER_MASTER_KEY, the key file in the project path,
+// the keyring or the key provider. Edit and the other commands which write the credentials use the project path
+func WithFS(fsys fs.FS) Option {
+	return func(s *Sicher) error {
+		s.fsys = fsys
+		return nil
+	}
+}
+
+// WithEditor sets the editor used by Edit when it is called without one
+func WithEditor(editor string) Option {
+	return func(s *Sicher) error {
+		s.editor = editor
+		return nil
+	}
+}
+
+// WithInput sets where the answers to prompts and passphrases are read, and the editor reads its input.
+// It is used by Initialize when it is given no reader. Defaults to os.Stdin
+func WithInput(stdin io.Reader) Option {
+	return func(s *Sicher) error {
+		s.stdin = stdin
+		return nil
+	}
+}
+
+// WithOutput sets where messages, prompts and the output of the editor are written.
+// Passphrase prompts are written to stderr. Defaults to os.Stdout and os.Stderr
+func WithOutput(stdout, stderr io.Writer) Option {
+	return func(s *Sicher) error {
+		s.stdout, s.stderr = stdout, stderr
+		return nil
+	}
+}
+
+// in returns the input of s
+func (s *Sicher) in() io.Reader {
+	if s.stdin != nil {
+		return s.stdin
+	}
+	return stdIn
+}
+
+// out returns the output of s
+func (s *Sicher) out() io.Writer {
+	if s.stdout != nil {
+		return s.stdout
+	}
+	return stdOut
+}
+
+// errOut returns the error output of s
+func (s *Sicher) errOut() io.Writer {
+	if s.stderr != nil {
+		return s.stderr
+	}
+	return stdErr
+}
@@ -0,0 +1,147 @@
+package sicher
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	dir := t.TempDir()
+	p := &TransitProvider{}
+	s, err := NewWithOptions(
+		WithPath(dir),
+		WithEnvironment("prod"),
+		WithEnvStyle(YAML),
+		WithKeyProvider(p),
+		WithKeyStore(KeyStoreKeyring),
+		WithGitignorePath(dir),
+		WithEditor("nano"),
+	)
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	if s.Path != dir+"/" || s.Environment != "prod" || s.envStyle != YAML {
+		t.Errorf("Expected path, environment and style to be set; got %s, %s, %s", s.Path, s.Environment, s.envStyle)
+	}
+	if s.keyProvider != p || s.keyStore != KeyStoreKeyring || s.gitignorePath != dir || s.editor != "nano" {
+		t.Errorf("Expected key provider, key store, gitignore path and editor to be set; got %+v", s)
+	}
+}
+
+func TestNewWithOptionsDefaults(t *testing.T) {
+	s, err := NewWithOptions()
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	path, _ := filepath.Abs(".")
+	if s.Path != path+"/" || s.Environment != defaultEnv || s.envStyle != DOTENV {
+		t.Errorf("Expected the defaults of New; got %s, %s, %s", s.Path, s.Environment, s.envStyle)
+	}
+}
+
+func TestNewWithOptionsInvalid(t *testing.T) {
+	if _, err := NewWithOptions(WithEnvStyle("ini")); err == nil {
+		t.Error("Expected an error for an invalid style")
+	}
+	if _, err := NewWithOptions(WithKeyStore("vault")); err == nil {
+		t.Error("Expected an error for an invalid key store")
+	}
+}
+
+func TestEditWithOptions(t *testing.T) {
+	oldExecCmd := execCmd
+	defer func() { execCmd = oldExecCmd }()
+
+	s := writeCredentials(t, DOTENV, "TESTKEY=loremipsum\n")
+	var out, errOut bytes.Buffer
+	for _, opt := range []Option{WithEditor("nano"), WithInput(os.Stdin), WithOutput(&out, &errOut)} {
+		opt(s)
+	}
+
+	execCmd = func(cmd string, args ...string) *exec.Cmd {
+		if cmd != "nano" {
+			t.Errorf("Expected command to be nano, got %s", cmd)
+		}
+		return exec.Command("sh", "-c", `echo "PORT=8080" > "$0"; echo edited >&2`, args[len(args)-1])
+	}
+
+	if err := s.Edit(); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if out.String() != "File encrypted and saved.\n" {
+		t.Errorf("Expected messages to be written to the output; got %q", out.String())
+	}
+	if errOut.String() != "edited\n" {
+		t.Errorf("Expected the editor to write to the error output; got %q", errOut.String())
+	}
+}
+
+func TestInitializeWithOptions(t *testing.T) {
+	t.Setenv(masterKey, "")
+	t.Setenv(passphraseEnv, "")
+	dir := t.TempDir() + "/"
+
+	// the passphrase is read from the input and prompted for on the error output
+	var out, errOut bytes.Buffer
+	s, err := NewWithOptions(WithPath(dir), WithInput(strings.NewReader("s3cret\ns3cret\n")), WithOutput(&out, &errOut))
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	s.UsePassphrase(KDFScrypt)
+	if err := s.Initialize(nil); err != nil {
+		t.Fatalf("Expected credentials to be initialized; got %v", err)
+	}
+	if !strings.Contains(errOut.String(), "Confirm passphrase") {
+		t.Errorf("Expected the passphrase prompts on the error output; got %q", errOut.String())
+	}
+
+	// the confirmation to overwrite them is read from the input too
+	out.Reset()
+	enc, _ := os.ReadFile(dir + "dev.enc")
+	s, _ = NewWithOptions(WithPath(dir), WithInput(strings.NewReader("no\n")), WithOutput(&out, &errOut))
+	if err := s.Initialize(nil); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if !strings.Contains(out.String(), "do you want to overwrite it?") || !strings.Contains(out.String(), "Leaving credentials file unmodified") {
+		t.Errorf("Expected the confirmation on the output; got %q", out.String())
+	}
+	if after, _ := os.ReadFile(dir + "dev.enc"); !bytes.Equal(enc, after) {
+		t.Errorf("Expected the credentials to be kept")
+	}
+}
+
+func TestWithFS(t *testing.T) {
+	key := testKey(t)
+	sealed, err := Seal(key, []byte("PORT=8080\n"), "prod", DOTENV)
+	if err != nil {
+		t.Fatalf("Unable to seal credentials; got error %v", err)
+	}
+	t.Setenv(masterKey, key)
+
+	fsys := fstest.MapFS{"prod.enc": &fstest.MapFile{Data: sealed}}
+	s, err := NewWithOptions(WithEnvironment("prod"), WithPath(t.TempDir()), WithFS(fsys))
+	if err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+
+	var cfg map[string]string
+	if err = s.Decode("", &cfg); err != nil {
+		t.Fatalf("Expected no error; got %v", err)
+	}
+	if cfg["PORT"] != "8080" {
+		t.Errorf("Expected the credentials to be loaded from the file system; got %v", cfg)
+	}
+
+	s, _ = NewWithOptions(WithEnvironment("dev"), WithFS(fsys))
+	if err = s.LoadEnv("", &cfg); !errors.Is(err, ErrCredentialsNotFound) {
+		t.Errorf("Expected ErrCredentialsNotFound for an environment missing from the file system; got %v", err)
+	}
+}
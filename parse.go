@@ -0,0 +1,179 @@
+package sicher
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LineError describes an entry of the credentials which could not be parsed
+type LineError struct {
+	// Line is the line number of the entry in the decrypted credentials, starting at 1.
+	// It is 0 if the line is not known, e.g. for json and toml keys
+	Line int
+
+	// Key is the key of the entry, if it could be read
+	Key string
+
+	Reason string
+}
+
+func (e LineError) String() string {
+	if e.Line == 0 {
+		return e.Reason
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// ParseError is returned when the decrypted credentials cannot be parsed. Outside of strict mode,
+// it is only returned for syntax errors which prevent reading the rest of the file, like an unterminated quote.
+// In strict mode, every entry that would otherwise be skipped is reported
+type ParseError struct {
+	Style EnvStyle
+	Lines []LineError
+}
+
+func (e *ParseError) Error() string {
+	reasons := make([]string, len(e.Lines))
+	for i, l := range e.Lines {
+		reasons[i] = l.String()
+	}
+	return fmt.Sprintf("invalid %s credentials: %s", e.Style, strings.Join(reasons, "; "))
+}
+
+// configParser stores the values parsed from credentials and collects the errors of entries which cannot be parsed
+type configParser struct {
+	store map[string]string
+
+	// strict reports entries which would otherwise be skipped, like lines without delimiter or invalid keys
+	strict bool
+
+	lines []LineError
+
+	// entries are the entries stored, in the order they were parsed
+	entries []parsedEntry
+
+	// seen holds the line of each key stored, to detect keys which are defined more than once
+	seen       map[string]int
+	duplicates []LineError
+
+	// warnings are entries which are parsed, but possibly not as intended, like unquoted dotenv values with a $
+	warnings []LineError
+
+	// merging is set while yaml maps merged with the << key are stored, whose keys may be overridden
+	merging bool
+}
+
+// parsedEntry is an entry of the credentials, as stored by the parser
+type parsedEntry struct {
+	line       int
+	key, value string
+}
+
+var keyRegex = regexp.MustCompile(envNameRegex)
+
+// parseConfigMode parses the credentials into store. In strict mode, entries which cannot be parsed are
+// reported in a *ParseError instead of being skipped
+func parseConfigMode(config []byte, store map[string]string, envType EnvStyle, strict bool) error {
+	p := &configParser{store: store, strict: strict}
+	if err := p.parse(config, envType); err != nil {
+		return err
+	}
+
+	// keys defined more than once are an error in strict mode, the last value is used otherwise
+	lines := p.lines
+	if strict && len(p.duplicates) > 0 {
+		lines = sortLines(append(append([]LineError{}, lines...), p.duplicates...))
+	}
+	if len(lines) > 0 {
+		return &ParseError{Style: envType, Lines: lines}
+	}
+	return nil
+}
+
+// parse parses the credentials of the given style, recording the errors in p
+func (p *configParser) parse(config []byte, envType EnvStyle) error {
+	if p.seen == nil {
+		p.seen = make(map[string]int)
+	}
+
+	switch envType {
+	case DOTENV:
+		p.parseDotenv(config)
+	case JSON:
+		p.parseJSON(config)
+	case TOML:
+		p.parseTOML(config)
+	case YAML, YML:
+		// yaml documents are parsed as such, falling back to KEY:value lines for files in the former style
+		if !p.parseYAML(config) {
+			p.parseLines(config, envStyleDelim[envType])
+		}
+	default:
+		return errors.New("invalid environment type")
+	}
+	return nil
+}
+
+// set stores the value of key, which must be a valid environment variable name
+func (p *configParser) set(line int, key, value string) {
+	if key == "" || !keyRegex.MatchString(key) {
+		p.skip(line, key, fmt.Sprintf("invalid key %q: only alphanumeric characters and _ are allowed", key))
+		return
+	}
+
+	if first, ok := p.seen[key]; ok {
+		reason := fmt.Sprintf("duplicate key %s", key)
+		if first > 0 {
+			reason = fmt.Sprintf("duplicate key %s overrides the value of line %d", key, first)
+		}
+		p.duplicate(line, key, reason)
+	} else if !p.merging {
+		p.seen[key] = line
+	}
+
+	p.store[key] = value
+	p.entries = append(p.entries, parsedEntry{line: line, key: key, value: value})
+}
+
+// duplicate records a key which is defined more than once
+func (p *configParser) duplicate(line int, key, reason string) {
+	p.duplicates = append(p.duplicates, LineError{Line: line, Key: key, Reason: reason})
+}
+
+// sortLines sorts errors by line, keeping the order of errors on the same line
+func sortLines(lines []LineError) []LineError {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Line < lines[j].Line })
+	return lines
+}
+
+// skip records an entry which is ignored, unless the parser is strict
+func (p *configParser) skip(line int, key, reason string) {
+	if p.strict {
+		p.fail(line, key, reason)
+	}
+}
+
+// fail records an entry which cannot be parsed
+func (p *configParser) fail(line int, key, reason string) {
+	p.lines = append(p.lines, LineError{Line: line, Key: key, Reason: reason})
+}
+
+// parseLines parses lines of the form KEY{delim}value
+func (p *configParser) parseLines(config []byte, delim string) {
+	for i, line := range strings.Split(string(config), "\n") {
+		line = strings.TrimSpace(line)
+		if canIgnore(line) {
+			continue
+		}
+
+		cfgLine := strings.Split(line, delim)
+		if len(cfgLine) < 2 {
+			p.skip(i+1, "", fmt.Sprintf("missing %q between key and value", delim))
+			continue
+		}
+		p.set(i+1, cfgLine[0], strings.Join(cfgLine[1:], delim))
+	}
+}
@@ -0,0 +1,170 @@
+package sicher
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigStrict(t *testing.T) {
+	tests := []struct {
+		name   string
+		style  EnvStyle
+		config string
+		lines  []LineError
+	}{
+		{
+			name:   "dotenv",
+			style:  DOTENV,
+			config: "PORT=8080\nHOST localhost\n\nAPP-URL=http://localhost\nNAME=sicher",
+			lines: []LineError{
+				{Line: 2, Reason: `missing "=" between key and value`},
+				{Line: 4, Key: "APP-URL", Reason: `invalid key "APP-URL": only alphanumeric characters and _ are allowed`},
+			},
+		},
+		{
+			name:   "legacy yaml",
+			style:  YAML,
+			config: "PORT:8080\nHOST=localhost\nNAME:sicher",
+			lines: []LineError{
+				{Line: 2, Reason: `missing ":" between key and value`},
+			},
+		},
+		{
+			name:   "yaml",
+			style:  YAML,
+			config: "PORT: 8080\nDB:\n  HOST: localhost\n  user-name: admin",
+			lines: []LineError{
+				{Line: 4, Key: "DB_user-name", Reason: `invalid key "DB_user-name": only alphanumeric characters and _ are allowed`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseConfigMode([]byte(tt.config), map[string]string{}, tt.style, true)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected *ParseError; got %v", err)
+			}
+			if !reflect.DeepEqual(parseErr.Lines, tt.lines) {
+				t.Errorf("Expected lines %+v; got %+v", tt.lines, parseErr.Lines)
+			}
+
+			// the same entries are skipped without error outside of strict mode
+			store := map[string]string{}
+			if err = parseConfigMode([]byte(tt.config), store, tt.style, false); err != nil {
+				t.Errorf("Expected no error outside of strict mode; got %v", err)
+			}
+			if store["PORT"] != "8080" {
+				t.Errorf("Expected valid entries to be parsed; got %v", store)
+			}
+		})
+	}
+}
+
+func TestParseConfigSyntaxError(t *testing.T) {
+	tests := []struct {
+		name   string
+		style  EnvStyle
+		config string
+		line   int
+	}{
+		{name: "dotenv", style: DOTENV, config: "PORT=8080\nKEY=\"unterminated\nNAME=sicher", line: 2},
+		{name: "json", style: JSON, config: "{\n  \"PORT\": 8080,\n  \"HOST\" \"localhost\"\n}", line: 3},
+		{name: "toml", style: TOML, config: "PORT = 8080\nHOST = localhost\nNAME = \"sicher\"", line: 2},
+		{name: "yaml", style: YAML, config: "PORT: 8080\nDB:\n  HOST: a\n bad", line: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// syntax errors are reported outside of strict mode too
+			err := parseConfigMode([]byte(tt.config), map[string]string{}, tt.style, false)
+
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected *ParseError; got %v", err)
+			}
+			if len(parseErr.Lines) != 1 || parseErr.Lines[0].Line != tt.line {
+				t.Errorf("Expected an error on line %d; got %+v", tt.line, parseErr.Lines)
+			}
+		})
+	}
+}
+
+func TestLoadEnvStrict(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "STRICT_PORT=8080\nSTRICT_HOST localhost\n")
+
+	var cfg map[string]string
+	if err := s.LoadEnv("", &cfg); err != nil {
+		t.Fatalf("Expected invalid lines to be skipped; got error %v", err)
+	}
+
+	s.SetStrict(true)
+	err := s.LoadEnv("", &cfg)
+	if err == nil {
+		t.Fatal("Expected an error in strict mode")
+	}
+	if !strings.Contains(err.Error(), `line 2: missing "=" between key and value`) {
+		t.Errorf("Expected the error to name the invalid line; got %v", err)
+	}
+}
+
+func TestParseConfigDuplicates(t *testing.T) {
+	tests := []struct {
+		name   string
+		style  EnvStyle
+		config string
+		lines  []LineError
+	}{
+		{
+			name:   "dotenv",
+			style:  DOTENV,
+			config: "PORT=8080\nHOST=localhost\nPORT=9090",
+			lines:  []LineError{{Line: 3, Key: "PORT", Reason: "duplicate key PORT overrides the value of line 1"}},
+		},
+		{
+			name:   "yaml",
+			style:  YAML,
+			config: "DB:\n  HOST: localhost\nDB_HOST: db.example.com",
+			lines:  []LineError{{Line: 3, Key: "DB_HOST", Reason: "duplicate key DB_HOST overrides the value of line 2"}},
+		},
+		{
+			name:   "json",
+			style:  JSON,
+			config: "{\n  \"DB\": {\n    \"HOST\": \"a\",\n    \"HOST\": \"b\"\n  }\n}",
+			lines:  []LineError{{Line: 4, Key: "DB_HOST", Reason: "duplicate key DB_HOST"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the last value is used outside of strict mode
+			if err := parseConfigMode([]byte(tt.config), map[string]string{}, tt.style, false); err != nil {
+				t.Errorf("Expected no error outside of strict mode; got %v", err)
+			}
+
+			err := parseConfigMode([]byte(tt.config), map[string]string{}, tt.style, true)
+			var parseErr *ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("Expected *ParseError; got %v", err)
+			}
+			if !reflect.DeepEqual(parseErr.Lines, tt.lines) {
+				t.Errorf("Expected lines %+v; got %+v", tt.lines, parseErr.Lines)
+			}
+		})
+	}
+}
+
+func TestParseYAMLMergeIsNotDuplicate(t *testing.T) {
+	config := "base: &base\n  HOST: localhost\n  PORT: 5432\ndb:\n  <<: *base\n  PORT: 6432"
+	store := map[string]string{}
+	if err := parseConfigMode([]byte(config), store, YAML, true); err != nil {
+		t.Errorf("Expected keys overriding merged keys to be valid; got %v", err)
+	}
+	if store["db_PORT"] != "6432" {
+		t.Errorf("Expected db_PORT to be overridden; got %v", store)
+	}
+}
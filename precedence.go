@@ -0,0 +1,88 @@
+package sicher
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Precedence decides which value is used when a credential is also set in the environment of the process
+type Precedence string
+
+const (
+	// PrecedenceFile uses the value of the credentials, overwriting the environment variable. It is the default
+	PrecedenceFile Precedence = "file"
+
+	// PrecedenceEnv uses the environment variable, so that an operator can override a credential, e.g. PORT=9090 ./app
+	PrecedenceEnv Precedence = "env"
+
+	// PrecedenceError fails if the environment variable and the credential have different values
+	PrecedenceError Precedence = "error"
+)
+
+// Source is where a loaded value came from
+type Source string
+
+const (
+	SourceCredentials Source = "credentials"
+	SourceEnvironment Source = "environment"
+)
+
+// SetPrecedence sets which value LoadEnv, Decode and Export use when a credential is also set in the environment
+func (s *Sicher) SetPrecedence(p Precedence) error {
+	if p != PrecedenceFile && p != PrecedenceEnv && p != PrecedenceError {
+		return fmt.Errorf("invalid precedence %q: select one of %s, %s or %s", p, PrecedenceFile, PrecedenceEnv, PrecedenceError)
+	}
+	s.precedence = p
+	return nil
+}
+
+// Sources returns where each value loaded by the last LoadEnv, Decode or Export came from:
+// the credentials or the environment. Variables which LoadEnv bound from the environment only are included
+func (s *Sicher) Sources() map[string]Source {
+	sources := make(map[string]Source, len(s.sources))
+	for k, v := range s.sources {
+		sources[k] = v
+	}
+	return sources
+}
+
+// resolve returns the values of the credentials, with the environment variables of the same name
+// taking their place if the environment takes precedence, and records their source
+func (s *Sicher) resolve() (map[string]string, error) {
+	values := make(map[string]string, len(s.data))
+	s.sources = make(map[string]Source, len(s.data))
+
+	var conflicts []string
+	for k, v := range s.data {
+		values[k], s.sources[k] = v, SourceCredentials
+
+		env, ok := os.LookupEnv(k)
+		if !ok || env == v {
+			continue
+		}
+		switch s.precedence {
+		case PrecedenceEnv:
+			values[k], s.sources[k] = env, SourceEnvironment
+		case PrecedenceError:
+			conflicts = append(conflicts, k)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, fmt.Errorf("credentials conflict with the environment variables %s", strings.Join(conflicts, ", "))
+	}
+	return values, nil
+}
+
+// getenv returns the environment variable key, recording the environment as the source of variables
+// which are not in the credentials
+func (s *Sicher) getenv(key string) string {
+	v, ok := os.LookupEnv(key)
+	if _, loaded := s.sources[key]; ok && !loaded {
+		s.sources[key] = SourceEnvironment
+	}
+	return v
+}
@@ -0,0 +1,85 @@
+package sicher
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPrecedence(t *testing.T) {
+	type Config struct {
+		Port  string `env:"PRECEDENCE_PORT"`
+		Host  string `env:"PRECEDENCE_HOST"`
+		Debug string `env:"PRECEDENCE_DEBUG"`
+	}
+
+	tests := []struct {
+		precedence Precedence
+		port       string
+		portSource Source
+		err        string
+	}{
+		{precedence: "", port: "8080", portSource: SourceCredentials},
+		{precedence: PrecedenceFile, port: "8080", portSource: SourceCredentials},
+		{precedence: PrecedenceEnv, port: "9090", portSource: SourceEnvironment},
+		{precedence: PrecedenceError, err: "credentials conflict with the environment variables PRECEDENCE_PORT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.precedence), func(t *testing.T) {
+			t.Setenv("PRECEDENCE_PORT", "9090")
+			t.Setenv("PRECEDENCE_HOST", "localhost")
+			t.Setenv("PRECEDENCE_DEBUG", "true")
+			s := writeCredentials(t, DOTENV, "PRECEDENCE_PORT=8080\nPRECEDENCE_HOST=localhost\n")
+			if tt.precedence != "" {
+				if err := s.SetPrecedence(tt.precedence); err != nil {
+					t.Fatalf("Expected no error setting precedence; got %v", err)
+				}
+			}
+
+			var cfg Config
+			err := s.LoadEnv("", &cfg)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Expected error containing %q; got %v", tt.err, err)
+				}
+				if os.Getenv("PRECEDENCE_PORT") != "9090" {
+					t.Error("Expected the environment to be left unchanged on conflict")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error; got %v", err)
+			}
+
+			if cfg.Port != tt.port || os.Getenv("PRECEDENCE_PORT") != tt.port {
+				t.Errorf("Expected port %s; got %s in the config and %s in the environment", tt.port, cfg.Port, os.Getenv("PRECEDENCE_PORT"))
+			}
+			expected := map[string]Source{
+				"PRECEDENCE_PORT":  tt.portSource,
+				"PRECEDENCE_HOST":  SourceCredentials,
+				"PRECEDENCE_DEBUG": SourceEnvironment,
+			}
+			if !reflect.DeepEqual(s.Sources(), expected) {
+				t.Errorf("Expected sources %v; got %v", expected, s.Sources())
+			}
+
+			// Decode applies the same precedence without reading variables missing from the credentials
+			var decoded Config
+			if err := s.Decode("", &decoded); err != nil {
+				t.Fatalf("Expected no error; got %v", err)
+			}
+			if decoded.Port != tt.port || decoded.Debug != "" {
+				t.Errorf("Expected port %s and no debug; got %+v", tt.port, decoded)
+			}
+		})
+	}
+}
+
+func TestSetPrecedenceInvalid(t *testing.T) {
+	s := New("testenv", t.TempDir())
+	if err := s.SetPrecedence("process"); err == nil {
+		t.Error("Expected an error for an invalid precedence")
+	}
+}
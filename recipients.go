@@ -0,0 +1,440 @@
+package sicher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/juju/fslock"
+)
+
+// recipientSymmetric is the type of recipients holding a symmetric key, like the one in {environment}.key
+const recipientSymmetric = "key"
+
+// recipient is a copy of the data key of the credentials, wrapped with the key of one recipient.
+// Credentials with recipients are encrypted with a random data key, which every recipient can unwrap with their own key
+type recipient struct {
+	// kind is one of recipientSymmetric, recipientX25519 or recipientKMS
+	kind string
+
+	// id is the fingerprint of a symmetric recipient key, the public key of an X25519 recipient
+	// or the name of a key provider
+	id string
+
+	nonce   []byte
+	wrapped []byte
+}
+
+// encode returns the recipient in the form of the "recipient" header field
+func (r *recipient) encode() string {
+	if r.kind == recipientX25519 || r.kind == recipientKMS {
+		return fmt.Sprintf("%s %s %x", r.kind, r.id, r.wrapped)
+	}
+	return fmt.Sprintf("%s %s %x %x", r.kind, r.id, r.nonce, r.wrapped)
+}
+
+// decodeRecipient parses the value of a "recipient" header field
+func decodeRecipient(value string) (*recipient, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid recipient %q", value)
+	}
+
+	r := &recipient{kind: fields[0], id: fields[1]}
+	var err error
+	switch {
+	case r.kind == recipientSymmetric && len(fields) == 4:
+		r.nonce, err = hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient nonce: %s", err)
+		}
+	case (r.kind == recipientX25519 || r.kind == recipientKMS) && len(fields) == 3:
+	default:
+		return nil, fmt.Errorf("invalid recipient %q", value)
+	}
+
+	r.wrapped, err = hex.DecodeString(fields[len(fields)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient key: %s", err)
+	}
+	return r, nil
+}
+
+// recipientID returns the id under which the given symmetric or public key is stored as a recipient
+func recipientID(key string) string {
+	if isPublicKey(key) {
+		return key
+	}
+	return keyFingerprint(key)
+}
+
+// wrapKeyFor wraps the data key for a recipient key, which is either a symmetric key or an age public key
+func wrapKeyFor(dataKey, key, environment string) (*recipient, error) {
+	if isPublicKey(key) {
+		return wrapKeyX25519(dataKey, key, environment)
+	}
+	return wrapKey(dataKey, key, environment)
+}
+
+// wrapAssociatedData binds a wrapped data key to the environment of the credentials
+func wrapAssociatedData(environment string) []byte {
+	return []byte("sicher-recipient\nenv=" + environment)
+}
+
+// wrapKey wraps the data key with the key of a recipient
+func wrapKey(dataKey, recipientKey, environment string) (*recipient, error) {
+	raw, err := hex.DecodeString(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, wrapped, err := encrypt(recipientKey, raw, wrapAssociatedData(environment))
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient key: %s", err)
+	}
+	return &recipient{kind: recipientSymmetric, id: keyFingerprint(recipientKey), nonce: nonce, wrapped: wrapped}, nil
+}
+
+// unwrapKey returns the data key of the credentials, using the recipient entry of the given key
+func unwrapKey(e *envelope, key, environment string) (string, error) {
+	r := e.findRecipient(keyFingerprint(key))
+	if r == nil {
+		return "", errors.New("the key is not a recipient of the credentials")
+	}
+
+	raw, err := decrypt(key, r.nonce, r.wrapped, wrapAssociatedData(environment))
+	if err != nil {
+		return "", fmt.Errorf("error unwrapping data key: %s", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// hasRecipientKind reports whether the credentials have a recipient of the given kind
+func (e *envelope) hasRecipientKind(kind string) bool {
+	for _, r := range e.recipients {
+		if r.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// findRecipient returns the recipient with the given id, or nil
+func (e *envelope) findRecipient(id string) *recipient {
+	for _, r := range e.recipients {
+		if r.id == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// unwrapDataKey returns the data key of credentials with recipients. It is unwrapped with the key
+// from SICHER_MASTER_KEY or the key file, by the key provider, or with an identity for X25519 recipients.
+// A key or identity which is available but not a recipient is reported as ErrDecryptionFailed
+func (s *Sicher) unwrapDataKey(e *envelope) (string, error) {
+	notRecipient := withSentinel(ErrDecryptionFailed, errors.New("the key is not a recipient of the credentials"))
+
+	key, keyErr := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment))
+	if keyErr == nil && e.findRecipient(keyFingerprint(key)) != nil {
+		dataKey, err := unwrapKey(e, key, s.Environment)
+		if err != nil {
+			return "", withSentinel(ErrDecryptionFailed, err)
+		}
+		return dataKey, nil
+	}
+
+	if e.hasRecipientKind(recipientKMS) {
+		dataKey, err := s.unwrapKeyKMS(e)
+		if err == nil || !e.hasRecipientKind(recipientX25519) {
+			return dataKey, err
+		}
+	}
+
+	if e.hasRecipientKind(recipientX25519) {
+		identities, err := s.getIdentities()
+		if err != nil {
+			if keyErr != nil {
+				return "", withSentinel(ErrKeyNotFound, fmt.Errorf("%s; %s", keyErr, err))
+			}
+			return "", notRecipient
+		}
+		dataKey, err := unwrapKeyX25519(e, identities, s.Environment)
+		if err != nil {
+			return "", withSentinel(ErrDecryptionFailed, err)
+		}
+		return dataKey, nil
+	}
+
+	if keyErr != nil {
+		return "", keyErr
+	}
+	return "", notRecipient
+}
+
+// AddRecipient allows the holder of key to decrypt the credentials. key is either a symmetric key,
+// as generated by GenerateKey, or an age X25519 public key ("age1...").
+// Credentials encrypted directly with a single key are converted to envelope encryption:
+// a new random data key encrypts the credentials and is wrapped for the current key and the new one
+func (s *Sicher) AddRecipient(key string) error {
+	key = strings.TrimSpace(key)
+	if isPublicKey(key) {
+		if _, err := age.ParseX25519Recipient(key); err != nil {
+			return fmt.Errorf("invalid public key: %s", err)
+		}
+	} else if b, err := hex.DecodeString(key); err != nil || len(b) != 32 {
+		return errors.New("recipient key must be a hex encoded 32 byte key, see sicher keygen, or an age public key")
+	}
+
+	return s.updateCredentials(func(e *envelope) ([]byte, error) {
+		if e.kdf != nil {
+			return nil, errors.New("passphrase protected credentials do not support recipients")
+		}
+		if e.findRecipient(recipientID(key)) != nil {
+			return nil, fmt.Errorf("%s is already a recipient", recipientID(key))
+		}
+
+		if len(e.recipients) > 0 {
+			dataKey, err := s.unwrapDataKey(e)
+			if err != nil {
+				return nil, err
+			}
+			r, err := wrapKeyFor(dataKey, key, s.Environment)
+			if err != nil {
+				return nil, err
+			}
+			e.recipients = append(e.recipients, r)
+			return e.encode(), nil
+		}
+
+		currentKey, err := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment))
+		if err != nil {
+			return nil, err
+		}
+		if keyFingerprint(currentKey) == recipientID(key) {
+			return nil, errors.New("the key already encrypts the credentials")
+		}
+
+		// convert to envelope encryption
+		plaintext, err := openFile(currentKey, e, s.Environment, s.envStyle)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting file: %s", err)
+		}
+
+		dataKey, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+
+		sealed := s.resealEnvelope(e)
+		for _, k := range []string{currentKey, key} {
+			r, err := wrapKeyFor(dataKey, k, s.Environment)
+			if err != nil {
+				return nil, err
+			}
+			sealed.recipients = append(sealed.recipients, r)
+		}
+		return sealFile(dataKey, plaintext, sealed)
+	})
+}
+
+// RemoveRecipient revokes the access of the recipient with the given id, as listed by ListRecipients.
+// A symmetric key may be given instead of its fingerprint. The other recipients keep their keys.
+//
+// The credentials are re-encrypted with a new data key, so that the removed key no longer opens them.
+// The new data key is wrapped with the keys of the remaining symmetric recipients, which must be
+// set with SetRecipientKeys, except for the key the credentials are opened with
+func (s *Sicher) RemoveRecipient(id string) error {
+	id = strings.TrimSpace(id)
+	if len(id) == 64 && !isPublicKey(id) {
+		id = keyFingerprint(id)
+	}
+
+	return s.updateCredentials(func(e *envelope) ([]byte, error) {
+		if e.findRecipient(id) == nil {
+			return nil, fmt.Errorf("%s is not a recipient of the credentials", id)
+		}
+		if len(e.recipients) == 1 {
+			return nil, errors.New("cannot remove the last recipient of the credentials")
+		}
+
+		// the caller must be able to open the credentials to change who can read them
+		dataKey, err := s.unwrapDataKey(e)
+		if err != nil {
+			return nil, err
+		}
+
+		var recipients []*recipient
+		for _, r := range e.recipients {
+			if r.id != id {
+				recipients = append(recipients, r)
+			}
+		}
+
+		keys := s.recipientKeys
+		if key, err := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment)); err == nil {
+			keys = append([]string{key}, keys...)
+		}
+		return s.rewrapCredentials(e, dataKey, recipients, keys)
+	})
+}
+
+// SetRecipientKeys sets the keys of other symmetric recipients. RemoveRecipient and RotateKey encrypt
+// the credentials with a new data key, which can only be wrapped for a symmetric recipient with its key
+func (s *Sicher) SetRecipientKeys(keys ...string) {
+	s.recipientKeys = nil
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			s.recipientKeys = append(s.recipientKeys, key)
+		}
+	}
+}
+
+// rewrapCredentials decrypts the credentials with dataKey and encrypts them with a new data key, wrapped for recipients.
+// Symmetric recipients are wrapped with their key from keys. The data key of a kms recipient is generated by its provider
+func (s *Sicher) rewrapCredentials(e *envelope, dataKey string, recipients []*recipient, keys []string) ([]byte, error) {
+	plaintext, err := openFile(dataKey, e, s.Environment, s.envStyle)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting file: %s", err)
+	}
+
+	symmetricKeys := make(map[string]string)
+	var missing []string
+	var kms *recipient
+	for _, k := range keys {
+		symmetricKeys[keyFingerprint(k)] = k
+	}
+	for _, r := range recipients {
+		switch r.kind {
+		case recipientSymmetric:
+			if _, ok := symmetricKeys[r.id]; !ok {
+				missing = append(missing, r.id)
+			}
+		case recipientKMS:
+			if kms != nil {
+				return nil, errors.New("the credentials have more than one key provider recipient")
+			}
+			kms = r
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the new data key can only be wrapped with the keys of the recipients %s, set them with SetRecipientKeys (-keys) or remove the recipients",
+			strings.Join(missing, ", "))
+	}
+
+	newDataKey, kmsWrapped := "", ""
+	if kms != nil {
+		p, err := s.externalProvider()
+		if err != nil {
+			return nil, err
+		}
+		w, ok := p.(KeyWrapper)
+		if !ok || w.Name() != kms.id {
+			return nil, fmt.Errorf("the key provider %s is needed to wrap the new data key", kms.id)
+		}
+		newDataKey, kmsWrapped, err = w.GenerateDataKey(s.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("error generating data key with %s: %s", kms.id, err)
+		}
+	} else {
+		newDataKey, err = GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sealed := s.resealEnvelope(e)
+	sealed.recipients = nil
+	sealed.pending = e.pending
+	for _, r := range recipients {
+		var wrapped *recipient
+		switch r.kind {
+		case recipientSymmetric:
+			wrapped, err = wrapKey(newDataKey, symmetricKeys[r.id], s.Environment)
+		case recipientX25519:
+			wrapped, err = wrapKeyX25519(newDataKey, r.id, s.Environment)
+		case recipientKMS:
+			wrapped = &recipient{kind: recipientKMS, id: r.id, wrapped: []byte(kmsWrapped)}
+		}
+		if err != nil {
+			return nil, err
+		}
+		sealed.recipients = append(sealed.recipients, wrapped)
+	}
+	return sealFile(newDataKey, plaintext, sealed)
+}
+
+// ListRecipients returns the ids of the recipients of the credentials: the fingerprints
+// of symmetric keys and the public keys of X25519 recipients.
+// It is empty if the credentials are encrypted directly with a single key
+func (s *Sicher) ListRecipients() ([]string, error) {
+	credFile, err := os.ReadFile(fmt.Sprintf("%s%s.enc", s.Path, s.Environment))
+	if err != nil {
+		return nil, fmt.Errorf("encrypted credentials file (%s.enc) is not available: %s", s.Environment, err)
+	}
+
+	e, err := decodeFile(string(credFile))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding encryption file: %s", err)
+	}
+
+	var ids []string
+	if e != nil {
+		for _, r := range e.recipients {
+			ids = append(ids, r.id)
+		}
+	}
+	return ids, nil
+}
+
+// updateCredentials locks the encrypted credentials file, decodes it and replaces
+// the file atomically with the content returned by update
+func (s *Sicher) updateCredentials(update func(e *envelope) ([]byte, error)) error {
+	encPath := fmt.Sprintf("%s%s.enc", s.Path, s.Environment)
+
+	credFile, err := os.Open(encPath)
+	if err != nil {
+		return fmt.Errorf("encrypted credentials file (%s.enc) is not available: %s", s.Environment, err)
+	}
+	defer credFile.Close()
+
+	credFileLock := fslock.New(encPath)
+	err = credFileLock.TryLock()
+	if err != nil {
+		if err == fslock.ErrLocked {
+			return fmt.Errorf("file is in use in another terminal")
+		}
+		return fmt.Errorf("error locking file: %s", err)
+	}
+	defer credFileLock.Unlock()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, credFile)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	e, err := decodeFile(buf.String())
+	if err != nil {
+		return fmt.Errorf("error decoding encryption file: %s", err)
+	}
+	if e == nil {
+		return errors.New("encrypted credentials file is empty")
+	}
+
+	updated, err := update(e)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(encPath, updated, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+	return nil
+}
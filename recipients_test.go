@@ -0,0 +1,151 @@
+package sicher
+
+import (
+	"os"
+	"testing"
+)
+
+// loadWithKey loads the credentials of s with the given key from SICHER_MASTER_KEY
+func loadWithKey(t *testing.T, s *Sicher, key string) (map[string]string, error) {
+	t.Helper()
+	os.Setenv(masterKey, key)
+	defer os.Unsetenv(masterKey)
+
+	enc, err := os.ReadFile(s.Path + s.Environment + ".enc")
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeFile(string(enc))
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.encryptionKey(e, false)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openFile(dataKey, e, s.Environment, s.envStyle)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := make(map[string]string)
+	err = parseConfig(plaintext, mp, s.envStyle)
+	return mp, err
+}
+
+// openWithDataKey opens the credentials of s with the given data key, as a former recipient could have kept it
+func openWithDataKey(t *testing.T, s *Sicher, dataKey string) error {
+	t.Helper()
+	enc, err := os.ReadFile(s.Path + s.Environment + ".enc")
+	if err != nil {
+		t.Fatalf("Unable to read credentials; got error %v", err)
+	}
+	e, err := decodeFile(string(enc))
+	if err != nil {
+		t.Fatalf("Unable to decode credentials; got error %v", err)
+	}
+	_, err = openFile(dataKey, e, s.Environment, s.envStyle)
+	return err
+}
+
+// dataKeyOf returns the data key of the credentials of s, unwrapped with key
+func dataKeyOf(t *testing.T, s *Sicher, key string) string {
+	t.Helper()
+	enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+	e, err := decodeFile(string(enc))
+	if err != nil {
+		t.Fatalf("Unable to decode credentials; got error %v", err)
+	}
+	dataKey, err := unwrapKey(e, key, s.Environment)
+	if err != nil {
+		t.Fatalf("Unable to unwrap data key; got error %v", err)
+	}
+	return dataKey
+}
+
+func TestRecipients(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, encPath, keyPath := setupTest()
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	ownKey, _ := os.ReadFile(keyPath)
+	aliceKey, bobKey := testKey(t), testKey(t)
+
+	if err := s.AddRecipient(aliceKey); err != nil {
+		t.Fatalf("Expected recipient to be added, got %v", err)
+	}
+	if err := s.AddRecipient(bobKey); err != nil {
+		t.Fatalf("Expected recipient to be added, got %v", err)
+	}
+	if err := s.AddRecipient(bobKey); err == nil {
+		t.Errorf("Expected adding an existing recipient to fail")
+	}
+	if err := s.AddRecipient("not a key"); err == nil {
+		t.Errorf("Expected adding an invalid key to fail")
+	}
+
+	ids, err := s.ListRecipients()
+	if err != nil || len(ids) != 3 {
+		t.Fatalf("Expected 3 recipients, got %v, %v", ids, err)
+	}
+
+	for _, key := range []string{string(ownKey), aliceKey, bobKey} {
+		mp, err := loadWithKey(t, s, key)
+		if err != nil || mp["TESTKEY"] != "loremipsum" {
+			t.Errorf("Expected every recipient to decrypt the credentials, got %v, %v", mp, err)
+		}
+	}
+
+	if _, err := loadWithKey(t, s, testKey(t)); err == nil {
+		t.Errorf("Expected a key which is not a recipient to be rejected")
+	}
+
+	// the new data key cannot be wrapped for alice without her key
+	if err := s.RemoveRecipient(keyFingerprint(bobKey)); err == nil {
+		t.Fatalf("Expected removing a recipient without the keys of the others to fail")
+	}
+
+	bobDataKey := dataKeyOf(t, s, bobKey)
+	s.SetRecipientKeys(aliceKey)
+	if err := s.RemoveRecipient(keyFingerprint(bobKey)); err != nil {
+		t.Fatalf("Expected recipient to be removed, got %v", err)
+	}
+	if _, err := loadWithKey(t, s, bobKey); err == nil {
+		t.Errorf("Expected removed recipient to no longer decrypt the credentials")
+	}
+	if err := openWithDataKey(t, s, bobDataKey); err == nil {
+		t.Errorf("Expected the data key known to the removed recipient to no longer decrypt the credentials")
+	}
+	if mp, err := loadWithKey(t, s, aliceKey); err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected remaining recipient to keep access, got %v, %v", mp, err)
+	}
+
+	// rotating the own key keeps the access of the other recipients
+	ownDataKey := dataKeyOf(t, s, string(ownKey))
+	if err := s.RotateKey(false); err != nil {
+		t.Fatalf("Expected key to be rotated, got %v", err)
+	}
+	newKey, _ := os.ReadFile(keyPath)
+	if _, err := loadWithKey(t, s, string(ownKey)); err == nil {
+		t.Errorf("Expected rotated key to no longer decrypt the credentials")
+	}
+	if err := openWithDataKey(t, s, ownDataKey); err == nil {
+		t.Errorf("Expected the data key known to the rotated key to no longer decrypt the credentials")
+	}
+	for _, key := range []string{string(newKey), aliceKey} {
+		if mp, err := loadWithKey(t, s, key); err != nil || mp["TESTKEY"] != "loremipsum" {
+			t.Errorf("Expected recipients to decrypt the credentials after rotation, got %v, %v", mp, err)
+		}
+	}
+
+	if err := s.RemoveRecipient(aliceKey); err != nil {
+		t.Fatalf("Expected recipient to be removed by key, got %v", err)
+	}
+	if err := s.RemoveRecipient(string(newKey)); err == nil {
+		t.Errorf("Expected removing the last recipient to fail")
+	}
+}
@@ -0,0 +1,172 @@
+package sicher
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juju/fslock"
+)
+
+// RotateKey replaces the encryption key of the environment.
+// The credentials are decrypted with the current key (from the key file or SICHER_MASTER_KEY),
+// re-encrypted with a newly generated key and the new key is written to the key file.
+// If backup is true, the old key is kept in {environment}.key.bak to allow a rollback.
+// If the key is kept in the OS keyring, the new key replaces it there and no key file is written.
+// Credentials with recipients get a new data key, which needs the keys of the other symmetric recipients, see SetRecipientKeys.
+func (s *Sicher) RotateKey(backup bool) error {
+	keyPath := fmt.Sprintf("%s%s.key", s.Path, s.Environment)
+	encPath := fmt.Sprintf("%s%s.enc", s.Path, s.Environment)
+
+	credFile, err := os.Open(encPath)
+	if err != nil {
+		return fmt.Errorf("encrypted credentials file (%s.enc) is not available: %s", s.Environment, err)
+	}
+	defer credFile.Close()
+
+	// lock the credentials file, so that it is not edited while being re-encrypted
+	credFileLock := fslock.New(encPath)
+	err = credFileLock.TryLock()
+	if err != nil {
+		if err == fslock.ErrLocked {
+			return fmt.Errorf("file is in use in another terminal")
+		}
+		return fmt.Errorf("error locking file: %s", err)
+	}
+	defer credFileLock.Unlock()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, credFile)
+	if err != nil {
+		return fmt.Errorf("%v", err)
+	}
+
+	envFile, err := decodeFile(buf.String())
+	if err != nil {
+		return fmt.Errorf("error decoding encryption file: %s", err)
+	}
+	if envFile == nil {
+		return errors.New("encrypted credentials file is empty, nothing to rotate")
+	}
+	if envFile.kdf != nil {
+		return errors.New("credentials are protected by a passphrase, there is no key to rotate")
+	}
+	if envFile.hasRecipientKind(recipientKMS) && !envFile.hasRecipientKind(recipientSymmetric) {
+		return errors.New("credentials are encrypted with a key provider, rotate the key in the provider instead")
+	}
+
+	key, source, err := s.findEncryptionKey(keyPath)
+	if err != nil {
+		return err
+	}
+
+	newKey, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	var encrypted []byte
+	if len(envFile.recipients) > 0 {
+		encrypted, err = s.rotateRecipient(envFile, key, newKey)
+		if err != nil {
+			return err
+		}
+	} else {
+		plaintext, err := openFile(key, envFile, s.Environment, s.envStyle)
+		if err != nil {
+			return fmt.Errorf("error decrypting file: %s", err)
+		}
+
+		encrypted, err = sealFile(newKey, plaintext, s.resealEnvelope(envFile))
+		if err != nil {
+			return fmt.Errorf("error encrypting file: %s", err)
+		}
+	}
+
+	if k, ok := source.(keyringProvider); ok {
+		return s.rotateKeyring(k, key, newKey, encPath, encrypted, backup)
+	}
+
+	if backup {
+		err = writeFileAtomic(keyPath+".bak", []byte(key), 0600)
+		if err != nil {
+			return fmt.Errorf("error saving key backup: %s", err)
+		}
+		if s.gitignorePath != "" {
+			err = addToGitignore(fmt.Sprintf("%s.key.bak", s.Environment), s.gitignorePath)
+			if err != nil {
+				return fmt.Errorf("error adding key backup to gitignore: %s", err)
+			}
+		}
+	}
+
+	// the new key is staged next to the key file before the credentials are replaced,
+	// so that it can be recovered if the process is interrupted in between
+	newKeyPath := keyPath + ".new"
+	err = writeFileAtomic(newKeyPath, []byte(newKey), 0600)
+	if err != nil {
+		return fmt.Errorf("error saving key file: %s", err)
+	}
+
+	err = writeFileAtomic(encPath, encrypted, 0600)
+	if err != nil {
+		cleanUpFile(newKeyPath)
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+
+	err = os.Rename(newKeyPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("error saving key file, the new key is available in %s: %s", newKeyPath, err)
+	}
+
+	fmt.Fprintf(s.out(), "Key rotated and credentials re-encrypted.\n")
+	if os.Getenv(masterKey) != "" {
+		fmt.Fprintf(s.out(), "%s is set, update it with the new key from %s.key.\n", masterKey, s.Environment)
+	}
+	return nil
+}
+
+// rotateKeyring replaces the key of credentials whose key is kept in the keyring, without creating a key file.
+// If backup is true, the old key is kept in the keyring under {environment}.bak
+func (s *Sicher) rotateKeyring(k keyringProvider, key, newKey, encPath string, encrypted []byte, backup bool) error {
+	if backup {
+		if err := k.store(s.Environment+".bak", key); err != nil {
+			return fmt.Errorf("error saving key backup: %s", err)
+		}
+	}
+
+	// the new key is saved first, so that the credentials are never left without it
+	if err := k.store(s.Environment, newKey); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(encPath, encrypted, 0600); err != nil {
+		if restoreErr := k.store(s.Environment, key); restoreErr != nil {
+			return fmt.Errorf("error writing encrypted credentials file: %s; the old key could not be restored in the keyring: %s", err, restoreErr)
+		}
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+
+	fmt.Fprintf(s.out(), "Key rotated, saved in the keyring and credentials re-encrypted.\n")
+	return nil
+}
+
+// rotateRecipient replaces the recipient entry of key with one for newKey and re-encrypts the credentials
+// with a new data key, which is wrapped for the other recipients with their keys, see SetRecipientKeys
+func (s *Sicher) rotateRecipient(e *envelope, key, newKey string) ([]byte, error) {
+	dataKey, err := unwrapKey(e, key, s.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	id := keyFingerprint(key)
+	recipients := make([]*recipient, len(e.recipients))
+	for i, r := range e.recipients {
+		recipients[i] = r
+		if r.id == id {
+			recipients[i] = &recipient{kind: recipientSymmetric, id: keyFingerprint(newKey)}
+		}
+	}
+	return s.rewrapCredentials(e, dataKey, recipients, append([]string{newKey}, s.recipientKeys...))
+}
@@ -0,0 +1,100 @@
+package sicher
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, encPath, keyPath := setupTest()
+
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+		os.Remove(keyPath + ".bak")
+	})
+
+	oldKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("Expected key file to have been created; got error %v", err)
+	}
+
+	err = s.RotateKey(true)
+	if err != nil {
+		t.Fatalf("Expected key to be rotated, got error %v", err)
+	}
+
+	newKey, err := os.ReadFile(keyPath)
+	if err != nil {
+		t.Fatalf("Expected key file to exist after rotation; got error %v", err)
+	}
+	if string(newKey) == string(oldKey) {
+		t.Errorf("Expected key to have changed after rotation")
+	}
+
+	backup, err := os.ReadFile(keyPath + ".bak")
+	if err != nil || string(backup) != string(oldKey) {
+		t.Errorf("Expected old key to be kept as backup, got %s, %v", backup, err)
+	}
+
+	if _, err := os.Stat(keyPath + ".new"); !os.IsNotExist(err) {
+		t.Errorf("Expected staged key file to have been removed")
+	}
+
+	enc, _ := os.ReadFile(encPath)
+	e, err := decodeFile(string(enc))
+	if err != nil {
+		t.Fatalf("Unable to decode rotated file; got error %v", err)
+	}
+	if _, err := openFile(string(oldKey), e, s.Environment, s.envStyle); err == nil {
+		t.Errorf("Expected old key to no longer decrypt the credentials")
+	}
+
+	mp := make(map[string]string)
+	err = s.LoadEnv("", &mp)
+	if err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be readable with the new key, got %v, %v", mp, err)
+	}
+}
+
+func TestRotateKeyWithoutCredentials(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, _, keyPath := setupTest()
+
+	os.WriteFile(keyPath, []byte(testKey(t)), 0600)
+	t.Cleanup(func() { os.Remove(keyPath) })
+
+	if err := s.RotateKey(false); err == nil {
+		t.Errorf("Expected error rotating without an encrypted credentials file")
+	}
+}
+
+func TestRotateKeyKeepsCipher(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, encPath, keyPath := setupTest()
+	if err := s.SetCipher(CipherXChaCha20Poly1305); err != nil {
+		t.Fatalf("Expected cipher to be valid, got error %v", err)
+	}
+
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	err := New(s.Environment, s.Path).RotateKey(false)
+	if err != nil {
+		t.Fatalf("Expected key to be rotated, got error %v", err)
+	}
+
+	enc, _ := os.ReadFile(encPath)
+	e, err := decodeFile(string(enc))
+	if err != nil {
+		t.Fatalf("Unable to decode rotated file; got error %v", err)
+	}
+	if e.cipher != CipherXChaCha20Poly1305 {
+		t.Errorf("Expected rotated credentials to keep cipher %s, got %s", CipherXChaCha20Poly1305, e.cipher)
+	}
+}
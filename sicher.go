@@ -11,10 +11,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strings"
 
 	"github.com/juju/fslock"
 )
@@ -38,7 +40,7 @@ var waitFlagmap = map[string]string{
 	"vimr": "--wait",
 }
 
-type sicher struct {
+type Sicher struct {
 	// Path is the path to the project. If empty string, it defaults to the current directory
 	Path string
 
@@ -50,27 +52,86 @@ type sicher struct {
 
 	// gitignorePath is the path to the .gitignore file
 	gitignorePath string
+
+	// kdf is the key derivation function used by Initialize to protect new credentials with a passphrase.
+	// If empty, a key file is created instead
+	kdf string
+
+	// passphrase of passphrase protected credentials. If empty, it is read from SICHER_PASSPHRASE or prompted for
+	passphrase string
+
+	// cipher is the cipher used to encrypt new credentials. If empty, DefaultCipher is used.
+	// Existing credentials keep the cipher recorded in their file
+	cipher string
+
+	// keyProvider is the external key provider, see SetKeyProvider
+	keyProvider KeyProvider
+
+	// keyStore is where Initialize saves the key of new credentials, see SetKeyStore
+	keyStore string
+
+	// recipientKeys are the keys of other symmetric recipients, see SetRecipientKeys
+	recipientKeys []string
+
+	// strict makes LoadEnv fail on entries of the credentials which cannot be parsed, see SetStrict
+	strict bool
+
+	// schema is checked by Edit before encrypting the edited credentials, see SetSchema
+	schema *schemaType
+
+	// precedence decides between the credentials and the environment variables of the same name, see SetPrecedence
+	precedence Precedence
+
+	// sources records where the values loaded last came from, see Sources
+	sources map[string]Source
+
+	// editor is the editor used by Edit when it is called without one. Defaults to vim
+	editor string
+
+	// stdin, stdout and stderr are used by Edit and RotateKey. If nil, the standard streams are used
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	// fsys is the file system the credentials are loaded from, see WithFS
+	fsys fs.FS
 }
 
-// New creates a new sicher struct
+// New creates a new Sicher, see NewWithOptions for more settings.
 // path is the path to the project. If empty string, it defaults to the current directory
 // environment is the environment to use. Defaults to "dev"
-func New(environment string, path string) *sicher {
+func New(environment string, path string) *Sicher {
+	// the options only fail on an invalid style
+	s, _ := NewWithOptions(WithEnvironment(environment), WithPath(path))
+	return s
+}
 
-	if environment == "" {
-		environment = defaultEnv
+// Initialize initializes the sicher project and creates the necessary files.
+// The confirmation to overwrite existing credentials is read from scanReader, or the input set with WithInput if nil
+func (s *Sicher) Initialize(scanReader io.Reader) error {
+	if scanReader == nil {
+		scanReader = s.in()
+	}
+	if s.kdf != "" {
+		return s.initializeWithPassphrase(scanReader)
 	}
 
-	if path == "" {
-		path = "."
+	// a key provider which wraps data keys replaces the key file
+	p, err := s.externalProvider()
+	if err != nil {
+		return err
+	}
+	if w, ok := p.(KeyWrapper); ok {
+		return s.initializeWithProvider(w, func() bool { return s.confirmOverwrite(scanReader) })
 	}
-	path, _ = filepath.Abs(path)
-	return &sicher{Path: path + "/", Environment: environment, data: make(map[string]string), envStyle: DOTENV}
-}
 
-// Initialize initializes the sicher project and creates the necessary files
-func (s *sicher) Initialize(scanReader io.Reader) error {
-	key := generateKey()
+	if s.keyStore == KeyStoreKeyring {
+		return s.initializeWithKeyring(func() bool { return s.confirmOverwrite(scanReader) })
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return err
+	}
 
 	// create the key file if it doesn't exist
 	keyFile, err := os.OpenFile(fmt.Sprintf("%s%s.key", s.Path, s.Environment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
@@ -107,19 +168,12 @@ func (s *sicher) Initialize(scanReader io.Reader) error {
 		// if yes, truncate file and continue
 		// else cancel
 		if encFileStats.Size() > 1 {
-			fmt.Printf("An encrypted credentials file already exist, do you want to overwrite it? \n Enter 'yes' or 'y' to accept.\n")
-			rd := bufio.NewScanner(scanReader)
-			for rd.Scan() {
-				line := rd.Text()
-				if line == "yes" || line == "y" {
-					encFile.Truncate(0)
-					break
-				} else {
-					cleanUpFile(keyFile.Name())
-					fmt.Println("Exiting. Leaving credentials file unmodified")
-					return nil
-				}
+			if !s.confirmOverwrite(scanReader) {
+				cleanUpFile(keyFile.Name())
+				fmt.Fprintln(s.out(), "Exiting. Leaving credentials file unmodified")
+				return nil
 			}
+			encFile.Truncate(0)
 		}
 
 		_, err = keyFile.WriteString(key)
@@ -136,12 +190,12 @@ func (s *sicher) Initialize(scanReader io.Reader) error {
 
 	// if the encrypted file is new, write some random data to it
 	if encFileStats.Size() < 1 {
-		initFile := []byte(fmt.Sprintf("TESTKEY%sloremipsum\n", envStyleDelim[s.envStyle]))
-		nonce, ciphertext, err := encrypt(key, initFile)
+		initFile := []byte(envLine(s.envStyle, "TESTKEY", "loremipsum"))
+		sealed, err := sealFile(key, initFile, s.newEnvelope())
 		if err != nil {
 			return fmt.Errorf("error encrypting credentials file: %s", err)
 		}
-		_, err = encFile.WriteString(fmt.Sprintf("%x%s%x", ciphertext, delimiter, nonce))
+		_, err = encFile.Write(sealed)
 		if err != nil {
 
 			return fmt.Errorf("error writing encrypted credentials file: %s", err)
@@ -158,11 +212,77 @@ func (s *sicher) Initialize(scanReader io.Reader) error {
 	return nil
 }
 
+// initializeWithPassphrase creates an encrypted credentials file protected by a passphrase.
+// No key file is created, the key is derived from the passphrase whenever the credentials are opened
+func (s *Sicher) initializeWithPassphrase(scanReader io.Reader) error {
+	kdf, err := newKDFParams(s.kdf)
+	if err != nil {
+		return err
+	}
+
+	encFile, err := os.OpenFile(fmt.Sprintf("%s%s.enc", s.Path, s.Environment), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("error creating encrypted credentials file: %s", err)
+	}
+	defer encFile.Close()
+
+	encFileStats, err := encFile.Stat()
+	if err != nil {
+		return fmt.Errorf("error getting credentials file stats: %s", err)
+	}
+
+	if encFileStats.Size() > 0 && !s.confirmOverwrite(scanReader) {
+		fmt.Fprintln(s.out(), "Exiting. Leaving credentials file unmodified")
+		return nil
+	}
+
+	passphrase, err := s.getPassphrase(true, true)
+	if err != nil {
+		return err
+	}
+
+	key, err := deriveKey(passphrase, kdf)
+	if err != nil {
+		return err
+	}
+
+	e := s.newEnvelope()
+	e.kdf = kdf
+	initFile := []byte(envLine(s.envStyle, "TESTKEY", "loremipsum"))
+	sealed, err := sealFile(key, initFile, e)
+	if err != nil {
+		return fmt.Errorf("error encrypting credentials file: %s", err)
+	}
+
+	encFile.Truncate(0)
+	_, err = encFile.WriteAt(sealed, 0)
+	if err != nil {
+		return fmt.Errorf("error writing encrypted credentials file: %s", err)
+	}
+	return nil
+}
+
+// confirmOverwrite asks the user whether an existing encrypted credentials file should be overwritten
+func (s *Sicher) confirmOverwrite(scanReader io.Reader) bool {
+	fmt.Fprintf(s.out(), "An encrypted credentials file already exist, do you want to overwrite it? \n Enter 'yes' or 'y' to accept.\n")
+	rd := bufio.NewScanner(scanReader)
+	if !rd.Scan() {
+		return false
+	}
+	line := rd.Text()
+	return line == "yes" || line == "y"
+}
+
 // Edit opens the encrypted credentials in a temporary file for editing. Default editor is vim.
-func (s *sicher) Edit(editor ...string) error {
+// If the edited credentials cannot be parsed, or do not match the schema set with SetSchema,
+// the user is asked to reopen the editor, save them anyway or discard the changes. Keys defined more
+// than once and unquoted dotenv values which expand a $ are reported as warnings.
+func (s *Sicher) Edit(editor ...string) error {
 	var editorName string
 	if len(editor) > 0 {
 		editorName = editor[0]
+	} else if s.editor != "" {
+		editorName = s.editor
 	} else {
 		editorName = "vim"
 	}
@@ -176,14 +296,16 @@ func (s *sicher) Edit(editor ...string) error {
 		cmdArgs = append(cmdArgs, waitOpt)
 	}
 
-	// read the encryption key. if key not in file, try getting from env
-	key, err := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment))
-	if err != nil {
-		return err
+	// a missing credentials file is created and encrypted with the key file or the key from env
+	encPath := fmt.Sprintf("%s%s.enc", s.Path, s.Environment)
+	if _, err := os.Stat(encPath); os.IsNotExist(err) {
+		if _, err := s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment)); err != nil {
+			return err
+		}
 	}
 
 	// open the encrypted credentials file
-	credFile, err := os.OpenFile(fmt.Sprintf("%s%s.enc", s.Path, s.Environment), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	credFile, err := os.OpenFile(encPath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("%v", err)
 	}
@@ -216,16 +338,29 @@ func (s *sicher) Edit(editor ...string) error {
 	defer cleanUpFile(filePath)
 
 	// if file already exists, decode and decrypt it
-	nonce, fileText, err := decodeFile(enc)
+	envFile, err := decodeFile(enc)
 	if err != nil {
-		return fmt.Errorf("error decoding encryption file: %s", err)
+		return withSentinel(ErrCorruptFile, fmt.Errorf("error decoding encryption file: %s", err))
+	}
+
+	// read the encryption key. if key not in file, try getting from env.
+	// the key of passphrase protected files is derived from the passphrase
+	key, err := s.encryptionKey(envFile, true)
+	if err != nil {
+		return err
 	}
 
 	var plaintext []byte
-	if nonce != nil && fileText != nil {
-		plaintext, err = decrypt(key, nonce, fileText)
+	if envFile != nil {
+		plaintext, err = openFile(key, envFile, s.Environment, s.envStyle)
 		if err != nil {
-			return fmt.Errorf("error decrypting file: %s", err)
+			return withSentinel(ErrDecryptionFailed, fmt.Errorf("error decrypting file: %s", err))
+		}
+
+		// secrets added with public keys are merged into the credentials when saving
+		plaintext, err = s.openPending(envFile, plaintext)
+		if err != nil {
+			return err
 		}
 
 		_, err = f.Write(plaintext)
@@ -236,51 +371,118 @@ func (s *sicher) Edit(editor ...string) error {
 
 	//open decrypted file with editor
 	cmdArgs = append(cmdArgs, filePath)
-	cmd := execCmd(editorName, cmdArgs...)
-	cmd.Stdin = stdIn
-	cmd.Stdout = stdOut
-	cmd.Stderr = stdErr
+	var file []byte
+	for {
+		cmd := execCmd(editorName, cmdArgs...)
+		cmd.Stdin = s.in()
+		cmd.Stdout = s.out()
+		cmd.Stderr = s.errOut()
+
+		err = cmd.Start()
+		if err != nil {
+			return fmt.Errorf("error starting editor: %s", err)
+		}
 
-	err = cmd.Start()
-	if err != nil {
-		return fmt.Errorf("error starting editor: %s", err)
-	}
+		err = cmd.Wait()
+		if err != nil {
+			return fmt.Errorf("error while editing %v", err)
+		}
 
-	err = cmd.Wait()
-	if err != nil {
-		return fmt.Errorf("error while editing %v", err)
-	}
+		file, err = os.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("error reading credentials file %v ", err)
+		}
 
-	file, err := os.ReadFile(filePath)
-	if err != nil {
-		return fmt.Errorf("error reading credentials file %v ", err)
-	}
+		// if no file changes, dont generate new encrypted file
+		// unless it has to be migrated to the current format
+		upToDate := envFile == nil || (envFile.version == formatVersion && len(envFile.pending) == 0)
+		if bytes.Equal(file, plaintext) && upToDate {
+			fmt.Fprintf(s.out(), "No changes made.\n")
+			return nil
+		}
 
-	// if no file changes, dont generate new encrypted file
-	if bytes.Equal(file, plaintext) {
-		fmt.Fprintf(stdOut, "No changes made.\n")
-		return nil
+		// invalid credentials are only encrypted if the user insists
+		warnings, invalid := s.validateCredentials(file)
+		for _, w := range warnings {
+			fmt.Fprintf(s.out(), "Warning: %s\n", w)
+		}
+		if invalid == nil {
+			break
+		}
+		action := promptInvalid(s.in(), s.out(), invalid)
+		if action == editSave {
+			break
+		}
+		if action == editDiscard {
+			fmt.Fprintf(s.out(), "Changes discarded.\n")
+			return nil
+		}
 	}
 
 	//encrypt and overwrite credentials file
-	// the encrypted file is encoded in hexadecimal format
-	nonce, encrypted, err := encrypt(key, file)
+	// files in older formats are rewritten in the current format
+	encrypted, err := sealFile(key, file, s.resealEnvelope(envFile))
 	if err != nil {
 		return fmt.Errorf("error encrypting file: %s ", err)
 	}
 
 	credFile.Truncate(0)
-	credFile.Write([]byte(fmt.Sprintf("%x%s%x", encrypted, delimiter, nonce)))
-	fmt.Fprintf(stdOut, "File encrypted and saved.\n")
+	credFile.Write(encrypted)
+	fmt.Fprintf(s.out(), "File encrypted and saved.\n")
 	return nil
 }
 
 // LoadEnv loads the environment variables from the encrypted credentials file into the config gile.
-// configFile can be a struct or map[string]string
-func (s *sicher) LoadEnv(prefix string, configFile interface{}) error {
-	s.configure()
-	s.setEnv()
+// configFile can be a struct or map[string]string. Struct fields can be of any scalar type, time.Duration,
+// url.URL, a type implementing encoding.TextUnmarshaler, or a slice or map of those. Slice elements and
+// map entries are separated by "," and the key and value of map entries by ":", which can be changed with
+// the separator and kvseparator tags, e.g. `env:"HOSTS" separator:";"`.
+// Nested structs are loaded with their env tag as prefix, e.g. DB_HOST for the field tagged HOST of a struct
+// field tagged DB. Embedded structs and struct fields without env tag share the prefix of their parent.
+// If the credentials cannot be parsed, a *ParseError listing the invalid lines is returned.
+//
+// LoadEnv also sets the credentials as environment variables of the process, where they are visible to child
+// processes, and binds the fields from the environment. Use Decode to keep the credentials out of the environment.
+func (s *Sicher) LoadEnv(prefix string, configFile interface{}) error {
+	values, err := s.load()
+	if err != nil {
+		return err
+	}
+	if err = s.setEnv(values); err != nil {
+		return err
+	}
+	return s.bind(prefix, configFile, values, s.getenv)
+}
+
+// Decode loads the credentials into configFile like LoadEnv, but without setting environment variables:
+// the fields are bound from the decrypted credentials only
+func (s *Sicher) Decode(prefix string, configFile interface{}) error {
+	values, err := s.load()
+	if err != nil {
+		return err
+	}
+	return s.bind(prefix, configFile, values, func(key string) string { return values[key] })
+}
 
+// Export sets the credentials as environment variables of the process. It is called by LoadEnv
+func (s *Sicher) Export() error {
+	values, err := s.load()
+	if err != nil {
+		return err
+	}
+	return s.setEnv(values)
+}
+
+// load reads the credentials and resolves them against the environment, see SetPrecedence
+func (s *Sicher) load() (map[string]string, error) {
+	if err := s.configure(); err != nil {
+		return nil, err
+	}
+	return s.resolve()
+}
+
+// bind sets configFile, a pointer to a struct or map[string]string, to values or from the variables returned by getenv
+func (s *Sicher) bind(prefix string, configFile interface{}, values map[string]string, getenv func(string) string) error {
 	d := reflect.ValueOf(configFile)
 	if d.Kind() == reflect.Ptr {
 		d = d.Elem()
@@ -297,59 +499,128 @@ func (s *sicher) LoadEnv(prefix string, configFile interface{}) error {
 		if d.Type() != reflect.TypeOf(map[string]string{}) {
 			return errors.New("configFile must be a struct or map[string]string")
 		}
-		d.Set(reflect.ValueOf(s.data))
+		d.Set(reflect.ValueOf(values))
 		return nil
 	}
 
 	// if the interface is a struct, iterate over the fields and set the values
-	for i := 0; i < d.NumField(); i++ {
-		field := d.Field(i)
-		fieldType := d.Type().Field(i)
-		isRequired := fieldType.Tag.Get("required")
-		key := fieldType.Tag.Get("env")
-
-		tagName := key
-		if prefix != "" {
-			tagName = fmt.Sprintf("%s_%s", prefix, key)
-		}
+	_, err := bindStruct(d, prefix, getenv)
+	return err
+}
 
-		envVar := os.Getenv(tagName)
-		if isRequired == "true" && envVar == "" {
-			return errors.New("required env variable " + key + " is not set")
-		}
+// SetEnvStyle sets the style of the decrypted credentials: dotenv, yaml, yml, json or toml
+func (s *Sicher) SetEnvStyle(style string) error {
+	if _, ok := envStyleExt[EnvStyle(style)]; !ok {
+		return fmt.Errorf("invalid style %q: select one of dotenv, yml, yaml, json or toml", style)
+	}
+	s.envStyle = EnvStyle(style)
+	return nil
+}
 
-		switch field.Kind() {
-		case reflect.String:
-			field.SetString(envVar)
-		case reflect.Bool:
-			field.SetBool(envVar == "true")
-		}
+func (s *Sicher) SetGitignorePath(path string) {
+	path, _ = filepath.Abs(path)
+	s.gitignorePath = path
+}
 
+// UsePassphrase makes Initialize protect the credentials with a passphrase instead of a key file.
+// kdf is the key derivation function, scrypt or argon2id. If empty, DefaultKDF is used
+func (s *Sicher) UsePassphrase(kdf string) error {
+	if kdf == "" {
+		kdf = DefaultKDF
+	}
+	if kdf != KDFScrypt && kdf != KDFArgon2id {
+		return fmt.Errorf("invalid key derivation function %q: select one of %s or %s", kdf, KDFScrypt, KDFArgon2id)
 	}
+	s.kdf = kdf
 	return nil
 }
 
-func (s *sicher) SetEnvStyle(style string) {
-	if style != "dotenv" && style != "yaml" && style != "yml" {
-		fmt.Println("Invalid style: Select one of dotenv, yml, or yaml")
-		os.Exit(1)
+// SetPassphrase sets the passphrase of passphrase protected credentials.
+// If not set, the passphrase is read from SICHER_PASSPHRASE or prompted for when editing
+func (s *Sicher) SetPassphrase(passphrase string) {
+	s.passphrase = passphrase
+}
+
+// SetStrict sets whether LoadEnv fails with a *ParseError on entries of the credentials which cannot be parsed,
+// like lines without delimiter or invalid keys, and on keys defined more than once.
+// By default, such entries are skipped and the last value of a key is used
+func (s *Sicher) SetStrict(strict bool) {
+	s.strict = strict
+}
+
+// SetCipher sets the cipher used by Initialize to encrypt new credentials, see Ciphers for the valid values
+func (s *Sicher) SetCipher(id string) error {
+	if _, err := lookupCipher(id); err != nil {
+		return fmt.Errorf("%s: select one of %s", err, strings.Join(Ciphers(), ", "))
 	}
-	s.envStyle = EnvStyle(style)
+	s.cipher = id
+	return nil
 }
 
-func (s *sicher) SetGitignorePath(path string) {
-	path, _ = filepath.Abs(path)
-	s.gitignorePath = path
+// newEnvelope returns the envelope for new credentials of the environment
+func (s *Sicher) newEnvelope() *envelope {
+	c := s.cipher
+	if c == "" {
+		c = DefaultCipher
+	}
+	return &envelope{environment: s.Environment, style: s.envStyle, cipher: c}
 }
 
-func (s *sicher) getEncryptionKey(filePath string) (string, error) {
-	encKey := os.Getenv(masterKey)
-	if encKey == "" {
-		key, err := os.ReadFile(filePath)
+// resealEnvelope returns the envelope for re-encrypting the credentials decoded from old.
+// The way the key is obtained and the cipher are carried over, old may be nil
+func (s *Sicher) resealEnvelope(old *envelope) *envelope {
+	e := s.newEnvelope()
+	if old != nil {
+		e.kdf = old.kdf
+		e.recipients = old.recipients
+		if s.cipher == "" {
+			e.cipher = old.cipher
+		}
+	}
+	return e
+}
+
+// encryptionKey returns the key that opens the credentials described by e.
+// Passphrase protected credentials derive the key from the passphrase, prompting for it if interactive is true.
+// Otherwise the key is read from SICHER_MASTER_KEY, the key file, the keyring or the key provider. If the credentials
+// have recipients, the data key is unwrapped with it, by the key provider or with an identity
+func (s *Sicher) encryptionKey(e *envelope, interactive bool) (string, error) {
+	if e != nil && e.kdf != nil {
+		passphrase, err := s.getPassphrase(interactive, false)
 		if err != nil {
-			return "", fmt.Errorf("encryption key(%s.key) is not available. Provide a key file or enter one through the command line", s.Environment)
+			return "", err
+		}
+		return deriveKey(passphrase, e.kdf)
+	}
+
+	if e != nil && len(e.recipients) > 0 {
+		return s.unwrapDataKey(e)
+	}
+	return s.getEncryptionKey(fmt.Sprintf("%s%s.key", s.Path, s.Environment))
+}
+
+// getEncryptionKey returns the key of the first key provider which has one:
+// SICHER_MASTER_KEY, the key file at filePath, the keyring or the external key provider
+func (s *Sicher) getEncryptionKey(filePath string) (string, error) {
+	key, _, err := s.findEncryptionKey(filePath)
+	return key, err
+}
+
+// findEncryptionKey returns the key like getEncryptionKey, along with the provider it was read from
+func (s *Sicher) findEncryptionKey(filePath string) (string, KeyProvider, error) {
+	providers, err := s.keyProviders(filePath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, p := range providers {
+		key, err := p.Key(s.Environment, "")
+		if err == nil {
+			return key, p, nil
+		}
+		if !errors.Is(err, ErrKeyUnavailable) {
+			return "", nil, fmt.Errorf("error reading key from %s: %s", p.Name(), err)
 		}
-		encKey = string(key)
 	}
-	return encKey, nil
+	return "", nil, withSentinel(ErrKeyNotFound, fmt.Errorf("encryption key(%s.key) is not available. Provide a key file or enter one through the command line", s.Environment))
 }
@@ -0,0 +1,280 @@
+package sicher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseJSON parses a json object into the store, flattening nested objects like parseYAML
+func (p *configParser) parseJSON(config []byte) {
+	if len(bytes.TrimSpace(config)) == 0 {
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(config))
+	dec.UseNumber()
+
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		line := 0
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line = bytes.Count(config[:syntaxErr.Offset], []byte("\n")) + 1
+		}
+		p.fail(line, "", fmt.Sprintf("invalid json: %s", err))
+		return
+	}
+	p.flattenValue(doc, "")
+	p.jsonDuplicates(config)
+}
+
+// jsonValue is an object or array of a json document being read token by token
+type jsonValue struct {
+	prefix string
+
+	// keys holds the names of the members of an object, it is nil for arrays
+	keys map[string]bool
+
+	// name is the name of the current member of an object, or empty if the next token is a name.
+	// index is the index of the next element of an array
+	name  string
+	index int
+}
+
+// child returns the flattened key of the current member or element
+func (v *jsonValue) child() string {
+	if v.keys == nil {
+		return joinKey(v.prefix, strconv.Itoa(v.index))
+	}
+	return joinKey(v.prefix, v.name)
+}
+
+// next moves past the current member or element
+func (v *jsonValue) next() {
+	v.name = ""
+	v.index++
+}
+
+// jsonDuplicates records the members of json objects which are defined more than once.
+// Decoding the document into a map keeps only the last of them
+func (p *configParser) jsonDuplicates(config []byte) {
+	dec := json.NewDecoder(bytes.NewReader(config))
+	var stack []*jsonValue
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+
+		var top *jsonValue
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		// the token is the name of a member
+		if name, ok := tok.(string); ok && top != nil && top.keys != nil && top.name == "" {
+			top.name = name
+			if top.keys[top.name] {
+				line := bytes.Count(config[:dec.InputOffset()], []byte("\n")) + 1
+				p.duplicate(line, top.child(), fmt.Sprintf("duplicate key %s", top.child()))
+			}
+			top.keys[top.name] = true
+			continue
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			v := &jsonValue{}
+			if top != nil {
+				v.prefix = top.child()
+			}
+			if tok == json.Delim('{') {
+				v.keys = make(map[string]bool)
+			}
+			stack = append(stack, v)
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+			if len(stack) > 0 {
+				stack[len(stack)-1].next()
+			}
+		default:
+			if top != nil {
+				top.next()
+			}
+		}
+	}
+}
+
+// parseTOML parses a toml document into the store, flattening tables like parseYAML
+func (p *configParser) parseTOML(config []byte) {
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(config), &doc); err != nil {
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			p.fail(parseErr.Position.Line, parseErr.LastKey, fmt.Sprintf("invalid toml: %s", parseErr.Message))
+			return
+		}
+		p.fail(0, "", fmt.Sprintf("invalid toml: %s", err))
+		return
+	}
+	p.flattenValue(doc, "")
+}
+
+// flattenValue stores the scalar values of a decoded json or toml document under key,
+// and the values of nested objects under key_child. Lists of scalars are joined with ",".
+// The documents are decoded without positions, so invalid keys are reported without line.
+// Members are stored in sorted order, so that of colliding keys the same one is always used and the other
+// reported as a duplicate: DB sorts before DB_HOST, so the value of DB_HOST overrides the one of DB.HOST
+func (p *configParser) flattenValue(v interface{}, key string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		children := make([]string, 0, len(v))
+		for child := range v {
+			children = append(children, child)
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			p.flattenValue(v[child], joinKey(key, child))
+		}
+	case []map[string]interface{}:
+		for i, value := range v {
+			p.flattenValue(value, joinKey(key, strconv.Itoa(i)))
+		}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := scalarString(item)
+			if !ok {
+				for i, item := range v {
+					p.flattenValue(item, joinKey(key, strconv.Itoa(i)))
+				}
+				return
+			}
+			values = append(values, s)
+		}
+		p.set(0, key, strings.Join(values, ","))
+	default:
+		if s, ok := scalarString(v); ok {
+			p.set(0, key, s)
+		}
+	}
+}
+
+// scalarString formats a scalar value of a decoded json or toml document
+func scalarString(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case int64, bool:
+		return fmt.Sprint(v), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	case fmt.Stringer:
+		// toml local dates and times
+		return v.String(), true
+	}
+	return "", false
+}
+
+// appendEntry adds an entry, as returned by envLine, to a credentials document of the given style
+func appendEntry(style EnvStyle, doc, entry []byte) []byte {
+	merged := append([]byte{}, doc...)
+
+	switch style {
+	case JSON:
+		// the members of the entry object are inserted into the document object
+		start, end := bytes.IndexByte(entry, '{'), bytes.LastIndexByte(entry, '}')
+		closing := bytes.LastIndexByte(merged, '}')
+		if start < 0 || end < start || closing < 0 {
+			break
+		}
+		member := bytes.TrimSpace(entry[start+1 : end])
+
+		open := bytes.IndexByte(merged, '{')
+		var insert []byte
+		if open >= 0 && len(bytes.TrimSpace(merged[open+1:closing])) > 0 {
+			insert = append(insert, ',')
+		}
+		insert = append(insert, "\n  "...)
+		insert = append(insert, member...)
+		insert = append(insert, '\n')
+
+		before := bytes.TrimRight(merged[:closing], " \t\r\n")
+		return append(append(append([]byte{}, before...), insert...), merged[closing:]...)
+	case TOML:
+		if replaced, ok := appendTOML(merged, entry); ok {
+			return replaced
+		}
+	}
+
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	return append(merged, entry...)
+}
+
+// appendTOML adds an entry to a TOML document. An existing top-level key of the entry is replaced in place,
+// otherwise the entry goes before the first table header, as keys after it would belong to the table.
+// It reports false if the entry should be appended to the end of the document
+func appendTOML(doc, entry []byte) ([]byte, bool) {
+	lines := bytes.SplitAfter(doc, []byte("\n"))
+	// decodeLines decodes the first n lines, reporting false if they are not a complete document
+	decodeLines := func(n int) (toml.MetaData, bool) {
+		var v map[string]interface{}
+		md, err := toml.Decode(string(bytes.Join(lines[:n], nil)), &v)
+		return md, err == nil
+	}
+	splice := func(start, end int) []byte {
+		merged := append(bytes.Join(lines[:start], nil), entry...)
+		return append(merged, bytes.Join(lines[end:], nil)...)
+	}
+
+	var v map[string]interface{}
+	entryMeta, err := toml.Decode(string(entry), &v)
+	if err != nil || len(entryMeta.Keys()) != 1 {
+		return nil, false
+	}
+	key := entryMeta.Keys()[0].String()
+
+	if md, ok := decodeLines(len(lines)); ok && md.IsDefined(key) && md.Type(key) != "Hash" && md.Type(key) != "ArrayHash" {
+		// the definition of the key spans the lines from the last complete document without it
+		// to the first one with it
+		start := 0
+		for n := 1; n <= len(lines); n++ {
+			md, ok := decodeLines(n)
+			if !ok {
+				continue
+			}
+			if md.IsDefined(key) {
+				return splice(start, n), true
+			}
+			start = n
+		}
+	}
+
+	for i, line := range lines {
+		// a line starting with "[" is a table header if the lines before it are a complete document,
+		// and not the element of a multi-line array or a line of a multi-line string
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("[")) {
+			continue
+		}
+		if _, ok := decodeLines(i); ok {
+			return splice(i, i), true
+		}
+	}
+	return nil, false
+}
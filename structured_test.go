@@ -0,0 +1,220 @@
+package sicher
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	cfg := []byte(`{
+  "PORT": 8080,
+  "RATIO": 0.5,
+  "DEBUG": true,
+  "EMPTY": null,
+  "DB": {"HOST": "localhost", "REPLICA": {"HOST": "replica"}},
+  "HOSTS": ["a.example.com", "b.example.com"],
+  "SERVERS": [{"NAME": "one"}, {"NAME": "two"}],
+  "invalid-key": "ignored"
+}`)
+
+	store := make(map[string]string)
+	if err := parseConfig(cfg, store, JSON); err != nil {
+		t.Fatalf("Unable to parse config; %v", err)
+	}
+
+	expected := map[string]string{
+		"PORT":            "8080",
+		"RATIO":           "0.5",
+		"DEBUG":           "true",
+		"EMPTY":           "",
+		"DB_HOST":         "localhost",
+		"DB_REPLICA_HOST": "replica",
+		"HOSTS":           "a.example.com,b.example.com",
+		"SERVERS_0_NAME":  "one",
+		"SERVERS_1_NAME":  "two",
+	}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected %v, got %v", expected, store)
+	}
+
+	for _, invalid := range []string{`["PORT"]`, `{"PORT": 8080`, `PORT=8080`} {
+		if err := parseConfig([]byte(invalid), map[string]string{}, JSON); err == nil {
+			t.Errorf("Expected %s not to be parsed as json", invalid)
+		}
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	cfg := []byte(`
+PORT = 8080
+RATIO = 0.5
+DEBUG = true
+HOSTS = ["a.example.com", "b.example.com"]
+STARTED = 2022-01-02T15:04:05Z
+
+[DB]
+HOST = "localhost"
+
+[DB.REPLICA]
+HOST = "replica"
+
+[[SERVERS]]
+NAME = "one"
+
+[[SERVERS]]
+NAME = "two"
+`)
+
+	store := make(map[string]string)
+	if err := parseConfig(cfg, store, TOML); err != nil {
+		t.Fatalf("Unable to parse config; %v", err)
+	}
+
+	expected := map[string]string{
+		"PORT":            "8080",
+		"RATIO":           "0.5",
+		"DEBUG":           "true",
+		"HOSTS":           "a.example.com,b.example.com",
+		"STARTED":         "2022-01-02T15:04:05Z",
+		"DB_HOST":         "localhost",
+		"DB_REPLICA_HOST": "replica",
+		"SERVERS_0_NAME":  "one",
+		"SERVERS_1_NAME":  "two",
+	}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected %v, got %v", expected, store)
+	}
+
+	if err := parseConfig([]byte("PORT: 8080"), map[string]string{}, TOML); err == nil {
+		t.Errorf("Expected invalid toml not to be parsed")
+	}
+}
+
+func TestAppendEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		style EnvStyle
+		doc   string
+	}{
+		{name: "json", style: JSON, doc: "{\n  \"PORT\": \"8080\"\n}\n"},
+		{name: "empty json", style: JSON, doc: "{}"},
+		{name: "toml", style: TOML, doc: "PORT = \"8080\"\n\n[DB]\nHOST = \"localhost\"\n"},
+		{name: "yaml", style: YAML, doc: "PORT: \"8080\""},
+		{name: "dotenv", style: DOTENV, doc: "PORT=8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := appendEntry(tt.style, []byte(tt.doc), []byte(envLine(tt.style, "SECRET", `a "quoted" value`)))
+
+			store := make(map[string]string)
+			if err := parseConfig(merged, store, tt.style); err != nil {
+				t.Fatalf("Unable to parse merged document %s; %v", merged, err)
+			}
+			if store["SECRET"] != `a "quoted" value` || (tt.name != "empty json" && store["PORT"] != "8080") {
+				t.Errorf("Unexpected values %v of merged document %s", store, merged)
+			}
+			if _, ok := store["DB_SECRET"]; ok {
+				t.Errorf("Expected entry not to be added to a table, got %s", merged)
+			}
+		})
+	}
+}
+
+func TestAppendEntryTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want map[string]string
+	}{
+		{
+			name: "multi-line array",
+			doc:  "HOSTS = [\n  [\"a\"],\n]\n\n[DB]\nHOST = \"localhost\"\n",
+			want: map[string]string{"HOSTS_0": "a", "DB_HOST": "localhost", "PORT": "9090"},
+		},
+		{
+			name: "multi-line string",
+			doc:  "NOTE = \"\"\"\n[not a table]\n\"\"\"\n",
+			want: map[string]string{"NOTE": "[not a table]\n", "PORT": "9090"},
+		},
+		{
+			name: "existing key",
+			doc:  "# port\nPORT = \"8080\"\nNAME = \"app\"\n\n[DB]\nHOST = \"localhost\"\n",
+			want: map[string]string{"PORT": "9090", "NAME": "app", "DB_HOST": "localhost"},
+		},
+		{
+			name: "existing multi-line key",
+			doc:  "PORT = [\n  8080,\n]\nNAME = \"app\"\n",
+			want: map[string]string{"PORT": "9090", "NAME": "app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged := appendEntry(TOML, []byte(tt.doc), []byte(envLine(TOML, "PORT", "9090")))
+
+			store := make(map[string]string)
+			if err := parseConfigMode(merged, store, TOML, true); err != nil {
+				t.Fatalf("Unable to parse merged document %s; %v", merged, err)
+			}
+			if !reflect.DeepEqual(store, tt.want) {
+				t.Errorf("Expected %v, got %v of merged document %s", tt.want, store, merged)
+			}
+		})
+	}
+}
+
+func TestFlattenCollisionIsDeterministic(t *testing.T) {
+	tests := []struct {
+		style  EnvStyle
+		config string
+	}{
+		{style: JSON, config: `{"DB_HOST": "a", "DB": {"HOST": "b"}}`},
+		{style: TOML, config: "DB_HOST = \"a\"\n\n[DB]\nHOST = \"b\"\n"},
+		// a secret added to a document with a table
+		{style: TOML, config: string(appendEntry(TOML, []byte("[DB]\nHOST = \"b\"\n"), []byte(envLine(TOML, "DB_HOST", "a"))))},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.style), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				store := make(map[string]string)
+				err := parseConfigMode([]byte(tt.config), store, tt.style, false)
+				if err != nil || store["DB_HOST"] != "a" {
+					t.Fatalf("Expected the flat key to win, got %v, %v", store, err)
+				}
+			}
+
+			var parseErr *ParseError
+			err := parseConfigMode([]byte(tt.config), map[string]string{}, tt.style, true)
+			if !errors.As(err, &parseErr) || !strings.Contains(err.Error(), "duplicate key DB_HOST") {
+				t.Errorf("Expected the collision to be reported in strict mode, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInitializeStructuredStyles(t *testing.T) {
+	t.Setenv(masterKey, "")
+
+	for _, style := range []string{"json", "toml"} {
+		t.Run(style, func(t *testing.T) {
+			s := New("testenv", t.TempDir())
+			s.SetEnvStyle(style)
+			if err := s.Initialize(os.Stdin); err != nil {
+				t.Fatalf("Expected credentials to be initialized, got error %v", err)
+			}
+
+			var cfg struct {
+				TestKey string `env:"TESTKEY"`
+			}
+			err := s.LoadEnv("", &cfg)
+			if err != nil || cfg.TestKey != "loremipsum" {
+				t.Errorf("Expected skeleton to be loaded, got %+v, %v", cfg, err)
+			}
+		})
+	}
+}
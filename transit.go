@@ -0,0 +1,167 @@
+package sicher
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var keyProviderTokenEnv = "SICHER_KEY_PROVIDER_TOKEN"
+
+// transitToken returns the token for the transit key provider from SICHER_KEY_PROVIDER_TOKEN or VAULT_TOKEN
+func transitToken() string {
+	if token := os.Getenv(keyProviderTokenEnv); token != "" {
+		return token
+	}
+	return os.Getenv("VAULT_TOKEN")
+}
+
+// TransitProvider is a KeyWrapper backed by an HTTP key management service with the API of the
+// Vault transit secrets engine. Data keys are generated and unwrapped by the service, so the
+// key that protects them never leaves it
+type TransitProvider struct {
+	// Address is the base URL of the service, e.g. https://vault.example.com:8200
+	Address string
+
+	// Mount is the path the transit engine is mounted at, e.g. transit
+	Mount string
+
+	// KeyName is the name of the transit key wrapping the data keys
+	KeyName string
+
+	// Token is sent in the X-Vault-Token header
+	Token string
+
+	Client *http.Client
+}
+
+// NewTransitProvider returns a provider for the transit key at keyURL,
+// e.g. https://vault.example.com:8200/v1/transit/keys/sicher
+func NewTransitProvider(keyURL, token string) (*TransitProvider, error) {
+	u, err := url.Parse(keyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q, expected a URL like https://host/v1/transit/keys/name", u.Scheme)
+	}
+
+	path := strings.TrimPrefix(strings.Trim(u.Path, "/"), "v1/")
+	i := strings.LastIndex(path, "/keys/")
+	if i < 1 || strings.Contains(path[i+len("/keys/"):], "/") || strings.HasSuffix(path, "/keys/") {
+		return nil, errors.New("expected a URL like https://host/v1/transit/keys/name")
+	}
+
+	return &TransitProvider{
+		Address: fmt.Sprintf("%s://%s", u.Scheme, u.Host),
+		Mount:   path[:i],
+		KeyName: path[i+len("/keys/"):],
+		Token:   token,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *TransitProvider) Name() string {
+	return fmt.Sprintf("%s/%s", p.Mount, p.KeyName)
+}
+
+// Key unwraps the data key with the transit key. The provider has no key of its own to hand out
+func (p *TransitProvider) Key(environment, wrapped string) (string, error) {
+	if wrapped == "" {
+		return "", ErrKeyUnavailable
+	}
+
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	err := p.do("decrypt", map[string]string{"ciphertext": wrapped}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return decodeTransitKey(resp.Plaintext)
+}
+
+// GenerateDataKey generates a data key with the transit key
+func (p *TransitProvider) GenerateDataKey(environment string) (key, wrapped string, err error) {
+	var resp struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	}
+	err = p.do("datakey/plaintext", map[string]interface{}{"bits": 256}, &resp)
+	if err != nil {
+		return "", "", err
+	}
+	if resp.Ciphertext == "" {
+		return "", "", errors.New("key provider returned no wrapped key")
+	}
+
+	key, err = decodeTransitKey(resp.Plaintext)
+	if err != nil {
+		return "", "", err
+	}
+	return key, resp.Ciphertext, nil
+}
+
+// decodeTransitKey converts a base64 encoded key returned by the service into a hex encoded key
+func decodeTransitKey(plaintext string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return "", fmt.Errorf("invalid key returned by key provider: %s", err)
+	}
+	if len(raw) != 32 {
+		return "", fmt.Errorf("key provider returned a %d byte key, 32 bytes are required", len(raw))
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// do posts body to the endpoint of the transit key and decodes the data of the response into data
+func (p *TransitProvider) do(endpoint string, body interface{}, data interface{}) error {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	endpointURL := fmt.Sprintf("%s/v1/%s/%s/%s", p.Address, p.Mount, endpoint, url.PathEscape(p.KeyName))
+	req, err := http.NewRequest(http.MethodPost, endpointURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("X-Vault-Token", p.Token)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error contacting key provider: %s", err)
+	}
+	defer res.Body.Close()
+
+	var resp struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&resp)
+	if res.StatusCode != http.StatusOK {
+		if len(resp.Errors) > 0 {
+			return fmt.Errorf("key provider returned %s: %s", res.Status, strings.Join(resp.Errors, "; "))
+		}
+		return fmt.Errorf("key provider returned %s", res.Status)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid response from key provider: %s", err)
+	}
+	return json.Unmarshal(resp.Data, data)
+}
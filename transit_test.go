@@ -0,0 +1,203 @@
+package sicher
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// newTransitServer starts a stand-in for the transit secrets engine, wrapping data keys for the key "sicher"
+func newTransitServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+	wrapKey := make([]byte, 32)
+	rand.Read(wrapKey)
+	block, _ := aes.NewCipher(wrapKey)
+	aead, _ := cipher.NewGCM(block)
+
+	writeError := func(w http.ResponseWriter, status int, msg string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			writeError(w, http.StatusForbidden, "permission denied")
+			return
+		}
+
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch r.URL.Path {
+		case "/v1/transit/datakey/plaintext/sicher":
+			key := make([]byte, 32)
+			nonce := make([]byte, aead.NonceSize())
+			rand.Read(key)
+			rand.Read(nonce)
+			wrapped := "vault:v1:" + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, key, nil))
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"plaintext":  base64.StdEncoding.EncodeToString(key),
+				"ciphertext": wrapped,
+			}})
+		case "/v1/transit/decrypt/sicher":
+			ciphertext, _ := req["ciphertext"].(string)
+			raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, "vault:v1:"))
+			if err != nil || len(raw) < aead.NonceSize() {
+				writeError(w, http.StatusBadRequest, "invalid ciphertext")
+				return
+			}
+			key, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "cipher: message authentication failed")
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]string{
+				"plaintext": base64.StdEncoding.EncodeToString(key),
+			}})
+		default:
+			writeError(w, http.StatusNotFound, "no handler for route")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNewTransitProvider(t *testing.T) {
+	p, err := NewTransitProvider("https://vault.example.com:8200/v1/secrets/transit/keys/sicher", "token")
+	if err != nil {
+		t.Fatalf("Expected key URL to be valid, got error %v", err)
+	}
+	if p.Address != "https://vault.example.com:8200" || p.Mount != "secrets/transit" || p.KeyName != "sicher" {
+		t.Errorf("Unexpected provider %s, %s, %s", p.Address, p.Mount, p.KeyName)
+	}
+	if p.Name() != "secrets/transit/sicher" {
+		t.Errorf("Unexpected provider name %s", p.Name())
+	}
+
+	for _, keyURL := range []string{"vault.example.com", "ftp://host/v1/transit/keys/sicher", "https://host/v1/transit/sicher", "https://host/v1/transit/keys/"} {
+		if _, err := NewTransitProvider(keyURL, ""); err == nil {
+			t.Errorf("Expected key URL %q to be rejected", keyURL)
+		}
+	}
+}
+
+func TestKeyProviderCredentials(t *testing.T) {
+	t.Setenv(masterKey, "")
+	srv := newTransitServer(t, "s.token")
+	t.Setenv(keyProviderEnv, srv.URL+"/v1/transit/keys/sicher")
+	t.Setenv(keyProviderTokenEnv, "s.token")
+
+	s, encPath, keyPath := setupTest()
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	err := s.Initialize(os.Stdin)
+	if err != nil {
+		t.Fatalf("Expected credentials to be initialized with the key provider, got error %v", err)
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Errorf("Expected no key file to be created")
+	}
+
+	enc, _ := os.ReadFile(encPath)
+	if !strings.Contains(string(enc), "recipient: kms transit/sicher ") {
+		t.Errorf("Expected wrapped data key to be stored in the credentials, got %s", enc)
+	}
+
+	mp := make(map[string]string)
+	err = New(s.Environment, s.Path).LoadEnv("", &mp)
+	if err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be readable through the key provider, got %v, %v", mp, err)
+	}
+
+	// the key provider is only consulted when there is no other key
+	key := testKey(t)
+	if err := s.AddRecipient(key); err != nil {
+		t.Fatalf("Expected recipient to be added, got error %v", err)
+	}
+	t.Setenv(keyProviderTokenEnv, "invalid")
+	if _, err := loadWithKey(t, s, key); err != nil {
+		t.Errorf("Expected credentials to be readable with the recipient key, got error %v", err)
+	}
+
+	_, err = loadWithKey(t, s, "")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected key provider error, got %v", err)
+	}
+}
+
+func TestSetKeyProvider(t *testing.T) {
+	t.Setenv(masterKey, "")
+	srv := newTransitServer(t, "s.token")
+	p, err := NewTransitProvider(srv.URL+"/v1/transit/keys/sicher", "s.token")
+	if err != nil {
+		t.Fatalf("Expected key URL to be valid, got error %v", err)
+	}
+
+	s, encPath, _ := setupTest()
+	s.SetKeyProvider(p)
+	t.Cleanup(func() { os.Remove(encPath) })
+
+	if err := s.Initialize(os.Stdin); err != nil {
+		t.Fatalf("Expected credentials to be initialized with the key provider, got error %v", err)
+	}
+
+	// without the provider, the credentials cannot be opened
+	if _, err := loadWithKey(t, New(s.Environment, s.Path), ""); err == nil {
+		t.Errorf("Expected credentials not to be readable without the key provider")
+	}
+
+	data, err := loadWithKey(t, s, "")
+	if err != nil || data["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be readable through the key provider, got %v, %v", data, err)
+	}
+
+	if err := s.RotateKey(false); err == nil {
+		t.Errorf("Expected rotating credentials of a key provider to be rejected")
+	}
+}
+
+// staticProvider is a KeyProvider like those implemented by users of the package, returning key or err
+type staticProvider struct {
+	key string
+	err error
+}
+
+func (p staticProvider) Name() string { return "static" }
+
+func (p staticProvider) Key(environment, wrapped string) (string, error) {
+	return p.key, p.err
+}
+
+func TestKeyProviderUnavailable(t *testing.T) {
+	s := writeCredentials(t, DOTENV, "PORT=8080\n")
+	keyPath := s.Path + s.Environment + ".key"
+	t.Setenv(keyringEnv, "")
+	oldLookPath := lookPath
+	t.Cleanup(func() { lookPath = oldLookPath })
+	lookPath = func(file string) (string, error) { return "", exec.ErrNotFound }
+
+	// a wrapped ErrKeyUnavailable moves on to the next provider, like a missing key
+	s.SetKeyProvider(staticProvider{err: fmt.Errorf("no key for dev: %w", ErrKeyUnavailable)})
+	os.Remove(keyPath)
+	if _, err := s.getEncryptionKey(keyPath); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for an unavailable key, got %v", err)
+	}
+
+	s.SetKeyProvider(staticProvider{err: errors.New("permission denied")})
+	if _, err := s.getEncryptionKey(keyPath); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Expected the error of the provider, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package sicher
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// actions offered by Edit when the edited credentials are invalid
+const (
+	editReopen  = "reopen"
+	editSave    = "save"
+	editDiscard = "discard"
+)
+
+// schemaType is the struct type the edited credentials are checked against, see SetSchema
+type schemaType struct {
+	prefix string
+	typ    reflect.Type
+}
+
+// SetSchema sets the struct that Edit checks the edited credentials against before encrypting them.
+// prefix and schema are the arguments given to LoadEnv: the credentials are invalid if a required
+// variable is missing or a value cannot be converted to the type of its field
+func (s *Sicher) SetSchema(prefix string, schema interface{}) error {
+	t := reflect.TypeOf(schema)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return errors.New("schema must be a struct or a pointer to a struct")
+	}
+	s.schema = &schemaType{prefix: prefix, typ: t}
+	return nil
+}
+
+// validateCredentials checks that the edited credentials can be parsed without skipping any entry,
+// and that they match the schema, if set. Keys defined more than once and unquoted dotenv values
+// which expand a $ are returned as warnings
+func (s *Sicher) validateCredentials(config []byte) ([]LineError, error) {
+	p := &configParser{store: make(map[string]string), strict: true}
+	if err := p.parse(config, s.envStyle); err != nil {
+		return nil, err
+	}
+	warnings := sortLines(append(append([]LineError{}, p.duplicates...), p.warnings...))
+	if len(p.lines) > 0 {
+		return warnings, &ParseError{Style: s.envStyle, Lines: p.lines}
+	}
+
+	if s.schema == nil {
+		return warnings, nil
+	}
+	getenv := func(key string) string { return p.store[key] }
+	_, err := bindStruct(reflect.New(s.schema.typ).Elem(), s.schema.prefix, getenv)
+	return warnings, err
+}
+
+// promptInvalid asks the user what to do with invalid credentials: reopen the editor, save them anyway
+// or discard the changes. The changes are discarded if no answer can be read
+func promptInvalid(r io.Reader, w io.Writer, invalid error) string {
+	fmt.Fprintf(w, "The credentials are invalid: %s\n", invalid)
+	for {
+		fmt.Fprintf(w, "What now? (e)dit again, (s)ave anyway, (d)iscard changes: ")
+		line, err := readLine(r)
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "e", "edit":
+			return editReopen
+		case "s", "save":
+			return editSave
+		case "d", "discard":
+			return editDiscard
+		}
+		if err != nil {
+			fmt.Fprintln(w)
+			return editDiscard
+		}
+	}
+}
+
+// readLine reads a line from r one byte at a time, so that nothing after the line is consumed
+// before the editor is reopened with the same input
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
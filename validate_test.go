@@ -0,0 +1,150 @@
+package sicher
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestValidateCredentials(t *testing.T) {
+	type Schema struct {
+		Port    int    `env:"PORT" required:"true"`
+		AppName string `env:"APP_NAME"`
+	}
+
+	tests := []struct {
+		name    string
+		schema  bool
+		config  string
+		invalid string
+	}{
+		{name: "valid", config: "PORT=8080\nAPP_NAME=sicher"},
+		{name: "missing delimiter", config: "PORT=8080\nAPP_NAME sicher", invalid: `line 2: missing "="`},
+		{name: "valid schema", schema: true, config: "PORT=8080"},
+		{name: "missing required", schema: true, config: "APP_NAME=sicher", invalid: "required env variable PORT"},
+		{name: "invalid type", schema: true, config: "PORT=eighty", invalid: "invalid value for env variable PORT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New("testenv", t.TempDir())
+			if tt.schema {
+				if err := s.SetSchema("", &Schema{}); err != nil {
+					t.Fatalf("Expected no error setting schema; got %v", err)
+				}
+			}
+
+			_, err := s.validateCredentials([]byte(tt.config))
+			if tt.invalid == "" && err != nil {
+				t.Errorf("Expected credentials to be valid; got %v", err)
+			}
+			if tt.invalid != "" && (err == nil || !strings.Contains(err.Error(), tt.invalid)) {
+				t.Errorf("Expected error containing %q; got %v", tt.invalid, err)
+			}
+		})
+	}
+}
+
+func TestSetSchemaInvalid(t *testing.T) {
+	s := New("testenv", t.TempDir())
+	if err := s.SetSchema("", map[string]string{}); err == nil {
+		t.Error("Expected an error for a schema which is not a struct")
+	}
+	if err := s.SetSchema("", nil); err == nil {
+		t.Error("Expected an error for a nil schema")
+	}
+}
+
+func TestPromptInvalid(t *testing.T) {
+	tests := []struct {
+		input  string
+		action string
+	}{
+		{input: "e\n", action: editReopen},
+		{input: "save\n", action: editSave},
+		{input: "D\n", action: editDiscard},
+		{input: "x\ns\n", action: editSave},
+		{input: "", action: editDiscard},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		action := promptInvalid(strings.NewReader(tt.input), &out, os.ErrInvalid)
+		if action != tt.action {
+			t.Errorf("Expected action %s for input %q; got %s", tt.action, tt.input, action)
+		}
+		if !strings.Contains(out.String(), "The credentials are invalid") {
+			t.Errorf("Expected the error to be shown; got %q", out.String())
+		}
+	}
+}
+
+func TestEditInvalidCredentials(t *testing.T) {
+	oldExecCmd, oldStdIn, oldStdOut := execCmd, stdIn, stdOut
+	defer func() { execCmd, stdIn, stdOut = oldExecCmd, oldStdIn, oldStdOut }()
+
+	tests := []struct {
+		name    string
+		answers string
+		edits   []string
+		want    string
+		output  string
+	}{
+		{name: "discard", answers: "d\n", edits: []string{"PORT 8080\n"}, want: "TESTKEY=loremipsum\n"},
+		{name: "save anyway", answers: "s\n", edits: []string{"PORT 8080\n"}, want: "PORT 8080\n"},
+		{name: "reopen", answers: "e\n", edits: []string{"PORT 8080\n", "PORT=8080\n"}, want: "PORT=8080\n"},
+		{name: "no answer", answers: "", edits: []string{"PORT 8080\n"}, want: "TESTKEY=loremipsum\n"},
+		{name: "duplicate", answers: "", edits: []string{"PORT=1\nPORT=2\n"}, want: "PORT=1\nPORT=2\n", output: "Warning: line 2: duplicate key PORT"},
+		{name: "unquoted $", answers: "", edits: []string{"DB_PASS=ab$cd\n"}, want: "DB_PASS=ab$cd\n", output: "Warning: line 1: unquoted value of DB_PASS expands $"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := writeCredentials(t, DOTENV, "TESTKEY=loremipsum\n")
+
+			var runs int
+			execCmd = func(cmd string, args ...string) *exec.Cmd {
+				content := tt.edits[runs]
+				runs++
+				return exec.Command("sh", "-c", `printf '%s' "$1" > "$0"`, args[len(args)-1], content)
+			}
+			// the answers are read from a file, like the terminal, which the editor gets as its stdin
+			answers, err := os.CreateTemp(t.TempDir(), "answers")
+			if err != nil {
+				t.Fatalf("Unable to create answers file; got error %v", err)
+			}
+			defer answers.Close()
+			answers.WriteString(tt.answers)
+			answers.Seek(0, 0)
+
+			var out bytes.Buffer
+			stdIn, stdOut = answers, &out
+
+			if err := s.Edit("vim"); err != nil {
+				t.Fatalf("Expected no error; got %v", err)
+			}
+			if !strings.Contains(out.String(), tt.output) {
+				t.Errorf("Expected output containing %q; got %q", tt.output, out.String())
+			}
+			if runs != len(tt.edits) {
+				t.Errorf("Expected the editor to run %d times; got %d", len(tt.edits), runs)
+			}
+
+			enc, _ := os.ReadFile(s.Path + s.Environment + ".enc")
+			key, _ := os.ReadFile(s.Path + s.Environment + ".key")
+			e, err := decodeFile(string(enc))
+			if err != nil {
+				t.Fatalf("Unable to decode credentials; got error %v", err)
+			}
+			plaintext, err := openFile(string(key), e, s.Environment, s.envStyle)
+			if err != nil {
+				t.Fatalf("Unable to open credentials; got error %v", err)
+			}
+			if string(plaintext) != tt.want {
+				t.Errorf("Expected credentials %q; got %q", tt.want, plaintext)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package sicher
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// recipientX25519 is the type of recipients identified by an age X25519 public key ("age1...").
+// Only holders of the matching identity ("AGE-SECRET-KEY-1...") can unwrap the data key
+const recipientX25519 = "x25519"
+
+var identityEnv = "SICHER_IDENTITY"
+
+// GenerateIdentity generates an X25519 identity. The identity must be kept secret, e.g. in the file
+// referenced by SICHER_IDENTITY, while the public key can be added as a recipient of credentials
+func GenerateIdentity() (identity string, publicKey string, err error) {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		return "", "", fmt.Errorf("error generating identity: %s", err)
+	}
+	return i.String(), i.Recipient().String(), nil
+}
+
+// isPublicKey reports whether key is an age X25519 public key rather than a symmetric key
+func isPublicKey(key string) bool {
+	return strings.HasPrefix(key, "age1")
+}
+
+// publicKeyEnvelope binds data encrypted to public keys to the environment,
+// since age has no associated data
+func publicKeyEnvelope(environment string) []byte {
+	return []byte(fmt.Sprintf("sicher-env=%s\n", environment))
+}
+
+// sealToPublicKeys encrypts the plaintext for the holders of the identities of the given public keys
+func sealToPublicKeys(plaintext []byte, environment string, publicKeys ...string) ([]byte, error) {
+	var recipients []age.Recipient
+	for _, publicKey := range publicKeys {
+		r, err := age.ParseX25519Recipient(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %s", publicKey, err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(append(publicKeyEnvelope(environment), plaintext...)); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// openWithIdentities decrypts data sealed with sealToPublicKeys for the given environment
+func openWithIdentities(sealed []byte, environment string, identities []age.Identity) ([]byte, error) {
+	r, err := age.Decrypt(bytes.NewReader(sealed), identities...)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := publicKeyEnvelope(environment)
+	if !bytes.HasPrefix(plaintext, prefix) {
+		return nil, fmt.Errorf("data was not encrypted for the %q environment", environment)
+	}
+	return plaintext[len(prefix):], nil
+}
+
+// wrapKeyX25519 wraps the data key for the holder of the identity of publicKey
+func wrapKeyX25519(dataKey, publicKey, environment string) (*recipient, error) {
+	raw, err := hex.DecodeString(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := sealToPublicKeys(raw, environment, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &recipient{kind: recipientX25519, id: publicKey, wrapped: wrapped}, nil
+}
+
+// unwrapKeyX25519 returns the data key of the credentials, using the first X25519 recipient
+// entry which one of the identities can open
+func unwrapKeyX25519(e *envelope, identities []age.Identity, environment string) (string, error) {
+	for _, r := range e.recipients {
+		if r.kind != recipientX25519 {
+			continue
+		}
+		raw, err := openWithIdentities(r.wrapped, environment, identities)
+		if err == nil {
+			return hex.EncodeToString(raw), nil
+		}
+	}
+	return "", errors.New("none of the identities is a recipient of the credentials")
+}
+
+// getIdentities reads the X25519 identities from the file referenced by SICHER_IDENTITY,
+// or from {environment}.identity in the project path
+func (s *Sicher) getIdentities() ([]age.Identity, error) {
+	filePath := os.Getenv(identityEnv)
+	if filePath == "" {
+		filePath = fmt.Sprintf("%s%s.identity", s.Path, s.Environment)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("identity file is not available. Provide its path through %s", identityEnv)
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading identity file: %s", err)
+	}
+	return identities, nil
+}
+
+// AddSecret adds a secret to the credentials using only the public keys of their X25519 recipients.
+// The secret is encrypted separately and cannot be read without an identity. It is merged into
+// the credentials by the next Edit of an identity holder, replacing an existing value of key.
+func (s *Sicher) AddSecret(key, value string) error {
+	if key == "" || !regexp.MustCompile(envNameRegex).MatchString(key) {
+		return fmt.Errorf("invalid key %q: only alphanumeric characters and _ are allowed", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.New("value must not contain line breaks")
+	}
+
+	return s.updateCredentials(func(e *envelope) ([]byte, error) {
+		var publicKeys []string
+		for _, r := range e.recipients {
+			if r.kind == recipientX25519 {
+				publicKeys = append(publicKeys, r.id)
+			}
+		}
+		if len(publicKeys) == 0 {
+			return nil, errors.New("the credentials have no public key recipients, add one with sicher recipients add")
+		}
+
+		line := []byte(envLine(s.fileStyle(e), key, value))
+		sealed, err := sealToPublicKeys(line, s.Environment, publicKeys...)
+		if err != nil {
+			return nil, err
+		}
+		e.pending = append(e.pending, sealed)
+		return e.encode(), nil
+	})
+}
+
+// fileStyle returns the style recorded in the header of the credentials, or the style of s for files
+// written before it was recorded
+func (s *Sicher) fileStyle(e *envelope) EnvStyle {
+	if e.style != "" {
+		return e.style
+	}
+	return s.envStyle
+}
+
+// openPending decrypts the secrets added with public keys and appends them to the plaintext,
+// so that they take precedence over earlier values
+func (s *Sicher) openPending(e *envelope, plaintext []byte) ([]byte, error) {
+	if len(e.pending) == 0 {
+		return plaintext, nil
+	}
+
+	identities, err := s.getIdentities()
+	if err != nil {
+		return nil, fmt.Errorf("credentials contain secrets added with a public key, which need an identity to be read: %s", err)
+	}
+
+	merged := plaintext
+	for _, sealed := range e.pending {
+		secret, err := openWithIdentities(sealed, s.Environment, identities)
+		if err != nil {
+			return nil, fmt.Errorf("error decrypting secret added with a public key: %s", err)
+		}
+		merged = appendEntry(s.fileStyle(e), merged, secret)
+	}
+	return merged, nil
+}
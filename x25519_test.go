@@ -0,0 +1,133 @@
+package sicher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeIdentity generates an identity, writes it to a file referenced by SICHER_IDENTITY and returns its public key
+func writeIdentity(t *testing.T) string {
+	t.Helper()
+	identity, publicKey, err := GenerateIdentity()
+	if err != nil {
+		t.Fatalf("Unable to generate identity; got error %v", err)
+	}
+
+	identityPath := filepath.Join(t.TempDir(), "test.identity")
+	os.WriteFile(identityPath, []byte("# public key: "+publicKey+"\n"+identity+"\n"), 0600)
+	os.Setenv(identityEnv, identityPath)
+	t.Cleanup(func() { os.Unsetenv(identityEnv) })
+	return publicKey
+}
+
+func TestPublicKeyRecipients(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, encPath, keyPath := setupTest()
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	publicKey := writeIdentity(t)
+	if err := s.AddRecipient(publicKey); err != nil {
+		t.Fatalf("Expected public key recipient to be added, got %v", err)
+	}
+	if err := s.AddRecipient("age1invalid"); err == nil {
+		t.Errorf("Expected invalid public key to be rejected")
+	}
+
+	// without the key file, the credentials are opened with the identity
+	ownKey, _ := os.ReadFile(keyPath)
+	os.Remove(keyPath)
+
+	if err := s.AddSecret("API_TOKEN", "added-with-public-key"); err != nil {
+		t.Fatalf("Expected secret to be added with the public key, got %v", err)
+	}
+	if err := s.AddSecret("INVALID KEY", "value"); err == nil {
+		t.Errorf("Expected invalid key to be rejected")
+	}
+
+	mp := make(map[string]string)
+	if err := s.LoadEnv("", &mp); err != nil {
+		t.Fatalf("Expected credentials to be loaded with the identity, got %v", err)
+	}
+	if mp["TESTKEY"] != "loremipsum" || mp["API_TOKEN"] != "added-with-public-key" {
+		t.Errorf("Expected credentials and added secret to be loaded, got %v", mp)
+	}
+
+	// the symmetric recipient cannot read the secret added with a public key
+	os.Unsetenv(identityEnv)
+	if _, err := loadWithKey(t, s, string(ownKey)); err != nil {
+		t.Errorf("Expected symmetric recipient to open the credentials, got %v", err)
+	}
+	enc, _ := os.ReadFile(encPath)
+	e, _ := decodeFile(string(enc))
+	if _, err := s.openPending(e, nil); err == nil {
+		t.Errorf("Expected secrets added with a public key to require an identity")
+	}
+}
+
+func TestRemovePublicKeyRecipientRotatesDataKey(t *testing.T) {
+	t.Setenv(masterKey, "")
+	s, encPath, keyPath := setupTest()
+	s.Initialize(os.Stdin)
+	t.Cleanup(func() {
+		os.Remove(encPath)
+		os.Remove(keyPath)
+	})
+
+	ownKey, _ := os.ReadFile(keyPath)
+	publicKey := writeIdentity(t)
+	_, otherPublicKey, _ := GenerateIdentity()
+
+	for _, key := range []string{publicKey, otherPublicKey} {
+		if err := s.AddRecipient(key); err != nil {
+			t.Fatalf("Expected recipient to be added, got %v", err)
+		}
+	}
+
+	enc, _ := os.ReadFile(encPath)
+	before, _ := decodeFile(string(enc))
+
+	if err := s.RemoveRecipient(keyFingerprint(string(ownKey))); err != nil {
+		t.Fatalf("Expected recipient to be removed, got %v", err)
+	}
+
+	enc, _ = os.ReadFile(encPath)
+	after, _ := decodeFile(string(enc))
+	if after.keyID == before.keyID {
+		t.Errorf("Expected data key to be replaced when only public key recipients remain")
+	}
+	if len(after.recipients) != 2 {
+		t.Errorf("Expected 2 recipients to remain, got %d", len(after.recipients))
+	}
+
+	os.Remove(keyPath)
+	mp := make(map[string]string)
+	if err := s.LoadEnv("", &mp); err != nil || mp["TESTKEY"] != "loremipsum" {
+		t.Errorf("Expected credentials to be loaded with the identity, got %v, %v", mp, err)
+	}
+}
+
+func TestAddSecretUsesFileStyle(t *testing.T) {
+	s := writeCredentials(t, YAML, "PORT: 8080\n")
+	if err := s.AddRecipient(writeIdentity(t)); err != nil {
+		t.Fatalf("Unable to add recipient; got error %v", err)
+	}
+
+	// the secret is added by a caller with the default dotenv style, like sicher add without -style
+	other := New(s.Environment, s.Path)
+	if err := other.AddSecret("API_KEY", "abc"); err != nil {
+		t.Fatalf("Expected secret to be added, got %v", err)
+	}
+
+	mp := make(map[string]string)
+	if err := s.LoadEnv("", &mp); err != nil {
+		t.Fatalf("Expected yaml credentials to be loaded, got %v", err)
+	}
+	if mp["PORT"] != "8080" || mp["API_KEY"] != "abc" {
+		t.Errorf("Expected the secret to be merged as yaml, got %v", mp)
+	}
+}
@@ -0,0 +1,136 @@
+package sicher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML parses a yaml document into the store. Nested maps are flattened into keys joined
+// with "_", e.g. PARENT_CHILD, and lists of scalars are joined with ",".
+// It reports false if the document is in the former KEY:value style, which is then parsed line by line.
+// Syntax errors of other documents are recorded with the line reported by yaml
+func (p *configParser) parseYAML(config []byte) bool {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(config, &doc); err != nil {
+		if isLegacyYAML(config) {
+			return false
+		}
+		line, reason := 0, strings.TrimPrefix(err.Error(), "yaml: ")
+		if m := yamlLineRegex.FindStringSubmatch(reason); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			reason = m[2]
+		}
+		p.fail(line, "", fmt.Sprintf("invalid yaml: %s", reason))
+		return true
+	}
+
+	// an empty document has no content
+	if len(doc.Content) == 0 {
+		return true
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return false
+	}
+
+	p.flattenYAML(root, "")
+	return true
+}
+
+// yamlLineRegex matches the line number of a yaml syntax error
+var yamlLineRegex = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// legacyYAMLRegex matches a line of the former KEY:value style, which has no space after the ":"
+// and may have an empty value, or a line without ":", which is skipped by the line parser
+var legacyYAMLRegex = regexp.MustCompile(`^([^\s:#]+:(\S|$)|[^\s:]+$)`)
+
+// isLegacyYAML reports whether all entries of config are lines of the former KEY:value style
+func isLegacyYAML(config []byte) bool {
+	for _, line := range strings.Split(string(config), "\n") {
+		if canIgnore(line) {
+			continue
+		}
+		if !legacyYAMLRegex.MatchString(strings.TrimSpace(line)) {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenYAML stores the scalar values of node under key, and the values of nested nodes under key_child
+func (p *configParser) flattenYAML(node *yaml.Node, key string) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		p.flattenYAML(node.Alias, key)
+	case yaml.MappingNode:
+		// merged maps (<<: *base) are stored first, so that the keys of the map itself override them
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "<<" {
+				p.flattenMerge(node.Content[i+1], key)
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value != "<<" {
+				p.flattenYAML(node.Content[i+1], joinKey(key, node.Content[i].Value))
+			}
+		}
+	case yaml.SequenceNode:
+		if values, ok := scalarValues(node); ok {
+			p.set(node.Line, key, strings.Join(values, ","))
+			return
+		}
+		for i, item := range node.Content {
+			p.flattenYAML(item, joinKey(key, strconv.Itoa(i)))
+		}
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			p.set(node.Line, key, "")
+			return
+		}
+		p.set(node.Line, key, node.Value)
+	}
+}
+
+// flattenMerge stores the maps merged into a map with the merge key, which is a map or a list of maps
+func (p *configParser) flattenMerge(node *yaml.Node, key string) {
+	merging := p.merging
+	p.merging = true
+	defer func() { p.merging = merging }()
+
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.SequenceNode {
+		for _, item := range node.Content {
+			p.flattenYAML(item, key)
+		}
+		return
+	}
+	p.flattenYAML(node, key)
+}
+
+// scalarValues returns the values of a list containing only scalars
+func scalarValues(node *yaml.Node) ([]string, bool) {
+	var values []string
+	for _, item := range node.Content {
+		if item.Kind == yaml.AliasNode {
+			item = item.Alias
+		}
+		if item.Kind != yaml.ScalarNode {
+			return nil, false
+		}
+		values = append(values, item.Value)
+	}
+	return values, true
+}
+
+func joinKey(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	return parent + "_" + child
+}
@@ -0,0 +1,124 @@
+package sicher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	cfg := []byte(`
+PORT: 8080
+NAME: "quoted: value # not a comment"
+DB:
+  HOST: localhost
+  PORT: 5432
+  REPLICA:
+    HOST: replica
+HOSTS:
+  - a.example.com
+  - b.example.com
+CERT: |
+  line one
+  line two
+EMPTY:
+SERVERS:
+  - NAME: one
+  - NAME: two
+defaults: &defaults
+  TIMEOUT: 5s
+  RETRIES: 3
+API:
+  <<: *defaults
+  RETRIES: 5
+invalid-key: ignored
+`)
+
+	store := make(map[string]string)
+	err := parseConfig(cfg, store, YAML)
+	if err != nil {
+		t.Fatalf("Unable to parse config; %v", err)
+	}
+
+	expected := map[string]string{
+		"PORT":             "8080",
+		"NAME":             "quoted: value # not a comment",
+		"DB_HOST":          "localhost",
+		"DB_PORT":          "5432",
+		"DB_REPLICA_HOST":  "replica",
+		"HOSTS":            "a.example.com,b.example.com",
+		"CERT":             "line one\nline two\n",
+		"EMPTY":            "",
+		"SERVERS_0_NAME":   "one",
+		"SERVERS_1_NAME":   "two",
+		"defaults_TIMEOUT": "5s",
+		"defaults_RETRIES": "3",
+		"API_TIMEOUT":      "5s",
+		"API_RETRIES":      "5",
+	}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected %v, got %v", expected, store)
+	}
+}
+
+func TestParseYAMLLegacyStyle(t *testing.T) {
+	store := make(map[string]string)
+	err := parseConfig([]byte("PORT:8080\nURI:mongodb://localhost:27017\nURL:http://x\nEMPTY:\n"), store, YML)
+	if err != nil {
+		t.Fatalf("Unable to parse config; %v", err)
+	}
+
+	expected := map[string]string{"PORT": "8080", "URI": "mongodb://localhost:27017", "URL": "http://x", "EMPTY": ""}
+	if !reflect.DeepEqual(store, expected) {
+		t.Errorf("Expected files in the former KEY:value style to be parsed line by line, got %v", store)
+	}
+}
+
+func TestParseYAMLLegacyStyleSyntax(t *testing.T) {
+	// the former style is parsed line by line even where it is not valid yaml
+	store := make(map[string]string)
+	err := parseConfig([]byte("PORT:8080\nQUERY:a=1: b\n"), store, YAML)
+	if err != nil || store["QUERY"] != "a=1: b" {
+		t.Errorf("Expected the former KEY:value style to be parsed; got %v, %v", store, err)
+	}
+
+	// a yaml document with a syntax error is not mistaken for the former style
+	store = make(map[string]string)
+	err = parseConfig([]byte("DB:\n  HOST: a\n bad"), store, YAML)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(store) != 0 {
+		t.Errorf("Expected a *ParseError for invalid yaml; got %v, %v", store, err)
+	}
+}
+
+func TestEnvLineYAML(t *testing.T) {
+	for _, value := range []string{"plain", "with: colon", "#hash", "", " padded "} {
+		store := make(map[string]string)
+		parseConfig([]byte(envLine(YAML, "KEY", value)), store, YAML)
+		if store["KEY"] != value {
+			t.Errorf("Expected %q to be read back, got %q", value, store["KEY"])
+		}
+	}
+}
+
+func TestLoadEnvNestedYAML(t *testing.T) {
+	s := writeCredentials(t, YAML, `
+DB:
+  HOST: localhost
+  PORTS: [5432, 5433]
+`)
+
+	var cfg struct {
+		DB struct {
+			Host  string `env:"HOST"`
+			Ports []int  `env:"PORTS"`
+		} `env:"DB"`
+	}
+	err := s.LoadEnv("", &cfg)
+	if err != nil {
+		t.Fatalf("Expected to load yaml config; got error %v", err)
+	}
+	if cfg.DB.Host != "localhost" || !reflect.DeepEqual(cfg.DB.Ports, []int{5432, 5433}) {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}